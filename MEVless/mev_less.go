@@ -6,6 +6,7 @@ import (
 	"github.com/cockroachdb/pebble"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
+	"github.com/yu-org/nine-tripods/utils/clock"
 	"github.com/yu-org/yu/common"
 	"github.com/yu-org/yu/core/context"
 	"github.com/yu-org/yu/core/tripod"
@@ -29,6 +30,8 @@ type MEVless struct {
 
 	wsClients map[*websocket.Conn]bool
 	wsLock    sync.Mutex
+
+	clock clock.Clock
 }
 
 const Prefix = "MEVless_"
@@ -44,6 +47,7 @@ func NewMEVless(cfg *Config) (*MEVless, error) {
 		commitmentsDB: db,
 		notifyCh:      make(chan *OrderCommitment, notifyBufferLen),
 		wsClients:     make(map[*websocket.Conn]bool),
+		clock:         clock.Wall,
 	}
 
 	tri.SetWritings(tri.OrderTx)
@@ -52,6 +56,11 @@ func NewMEVless(cfg *Config) (*MEVless, error) {
 	return tri, nil
 }
 
+// WithClock makes MEVless wait on clk instead of the wall clock, it must be called before the chain starts.
+func (m *MEVless) WithClock(clk clock.Clock) {
+	m.clock = clk
+}
+
 func (m *MEVless) CheckTxn(stxn *types.SignedTxn) error {
 	// Just for print log
 	hashStr := strings.TrimPrefix(stxn.GetParams(), Prefix)
@@ -119,7 +128,7 @@ func (m *MEVless) OrderCommitment(blockNum common.BlockNum) error {
 	// TODO: sync the OrderCommitment to other P2P nodes
 
 	// sleep for a while so that clients can send their tx-content onchain.
-	time.Sleep(5000 * time.Millisecond)
+	m.clock.Sleep(5000 * time.Millisecond)
 
 	m.Pool.Reset(hashTxns)
 
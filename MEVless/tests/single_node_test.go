@@ -32,6 +32,8 @@ func TestMEVless(t *testing.T) {
 func runChain(t *testing.T, wg *sync.WaitGroup) {
 
 	poaCfg := poa.DefaultCfg(0)
+	// the only node makes every block
+	poaCfg.Validators = poaCfg.Validators[:1]
 	mevLessCfg := MEVless.DefaultCfg()
 	yuCfg := startup.InitDefaultKernelConfig()
 	yuCfg.MaxBlockNum = 10
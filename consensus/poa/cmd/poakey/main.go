@@ -0,0 +1,150 @@
+// poakey manages the keystore files of poa validators.
+//
+//	poakey generate -key-type sr25519 -out validator.json
+//	poakey import -key-type sr25519 -secret-file secret.txt -out validator.json
+//	poakey export -keystore validator.json
+//
+// The passphrase is read from -passphrase-file, or the env var POA_KEYSTORE_PASSPHRASE.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/core/keypair"
+	"io"
+	"os"
+	"strings"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "generate":
+		err = generate(os.Args[2:])
+	case "import":
+		err = importSecret(os.Args[2:])
+	case "export":
+		err = export(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: poakey <generate|import|export> [flags]")
+	os.Exit(2)
+}
+
+func passphraseFlags(fs *flag.FlagSet) (env, file *string) {
+	env = fs.String("passphrase-env", poa.DefaultPassphraseEnv, "env var holding the passphrase")
+	file = fs.String("passphrase-file", "", "file holding the passphrase, used first if set")
+	return
+}
+
+func generate(args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	keyType := fs.String("key-type", keypair.Sr25519, "sr25519, ed25519 or secp256k1")
+	out := fs.String("out", "", "path of the new keystore file")
+	env, file := passphraseFlags(fs)
+	_ = fs.Parse(args)
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+
+	passphrase, err := poa.ReadPassphrase(*env, *file)
+	if err != nil {
+		return err
+	}
+	ks, err := poa.GenKeystore(*keyType, passphrase)
+	if err != nil {
+		return err
+	}
+	err = ks.Save(*out)
+	if err != nil {
+		return err
+	}
+	fmt.Println(ks.Pubkey)
+	return nil
+}
+
+func importSecret(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	keyType := fs.String("key-type", keypair.Sr25519, "sr25519, ed25519 or secp256k1")
+	secretFile := fs.String("secret-file", "", "file holding the secret, read from stdin if not set")
+	out := fs.String("out", "", "path of the new keystore file")
+	env, file := passphraseFlags(fs)
+	_ = fs.Parse(args)
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+
+	var (
+		secret []byte
+		err    error
+	)
+	if *secretFile != "" {
+		secret, err = os.ReadFile(*secretFile)
+	} else {
+		secret, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return err
+	}
+	secret = []byte(strings.TrimRight(string(secret), "\r\n"))
+	if poa.IsDefaultSecret(secret) {
+		fmt.Fprintln(os.Stderr, "warning: the secret is one of the default secrets, it cannot run in production")
+	}
+
+	passphrase, err := poa.ReadPassphrase(*env, *file)
+	if err != nil {
+		return err
+	}
+	ks, err := poa.NewKeystore(*keyType, secret, passphrase)
+	if err != nil {
+		return err
+	}
+	err = ks.Save(*out)
+	if err != nil {
+		return err
+	}
+	fmt.Println(ks.Pubkey)
+	return nil
+}
+
+func export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	keystore := fs.String("keystore", "", "path of the keystore file")
+	pubkeyOnly := fs.Bool("pubkey", false, "print the pubkey only, no passphrase needed")
+	env, file := passphraseFlags(fs)
+	_ = fs.Parse(args)
+	if *keystore == "" {
+		return fmt.Errorf("-keystore is required")
+	}
+
+	ks, err := poa.LoadKeystore(*keystore)
+	if err != nil {
+		return err
+	}
+	if *pubkeyOnly {
+		fmt.Println(ks.Pubkey)
+		return nil
+	}
+	passphrase, err := poa.ReadPassphrase(*env, *file)
+	if err != nil {
+		return err
+	}
+	secret, err := ks.Decrypt(passphrase)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(secret))
+	return nil
+}
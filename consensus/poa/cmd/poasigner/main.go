@@ -0,0 +1,106 @@
+// poasigner holds the key of a poa validator in its own process, and signs for the node over a unix socket.
+//
+//	poasigner -keystore validator.json -socket /run/poa/signer.sock -state /var/lib/poasigner/state.json
+//
+// The node connects it by remote_signer in the poa config.
+// The highest signed block is kept in the state file, it never signs two blocks on one height and round.
+// The votes and round-changes are guarded the same way, by the files of the state path with ".vote" and ".round_change".
+// -override-height forgets the signed block on the height, when it was never published.
+// The passphrase is read from -passphrase-file, or the env var POA_KEYSTORE_PASSPHRASE.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	keystore := flag.String("keystore", "", "path of the keystore file")
+	socket := flag.String("socket", "", "path of the unix socket to listen on")
+	statePath := flag.String("state", "", "path of the file keeping the highest signed block")
+	env := flag.String("passphrase-env", poa.DefaultPassphraseEnv, "env var holding the passphrase")
+	file := flag.String("passphrase-file", "", "file holding the passphrase, used first if set")
+	override := flag.Uint64("override-height", 0,
+		"forget the signed block on the height, only if it was never published")
+	flag.Parse()
+
+	err := run(*keystore, *socket, *statePath, *env, *file, *override)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(keystore, socket, statePath, env, file string, override uint64) error {
+	if keystore == "" || socket == "" || statePath == "" {
+		return fmt.Errorf("-keystore, -socket and -state are required")
+	}
+
+	ks, err := poa.LoadKeystore(keystore)
+	if err != nil {
+		return err
+	}
+	passphrase, err := poa.ReadPassphrase(env, file)
+	if err != nil {
+		return err
+	}
+	secret, err := ks.Decrypt(passphrase)
+	if err != nil {
+		return err
+	}
+	if poa.IsDefaultSecret(secret) {
+		return fmt.Errorf("the keystore holds one of the default secrets")
+	}
+	pub, priv, err := ks.KeyPair(passphrase)
+	if err != nil {
+		return err
+	}
+	server, err := poa.NewSignerServer(poa.NewLocalSigner(pub, priv), statePath)
+	if err != nil {
+		return err
+	}
+	if override > 0 {
+		overridden, err := server.Override(common.BlockNum(override))
+		if err != nil {
+			return err
+		}
+		if !overridden {
+			return fmt.Errorf("no block is signed on height(%d) last time", override)
+		}
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(socket, 0600)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		<-sigCh
+		close(stopped)
+		// the socket file is removed on closing
+		listener.Close()
+	}()
+
+	fmt.Printf("signing for %s on %s\n", ks.Pubkey, socket)
+	err = server.Serve(listener)
+	select {
+	case <-stopped:
+		return nil
+	default:
+		return err
+	}
+}
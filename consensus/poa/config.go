@@ -2,29 +2,99 @@ package poa
 
 import (
 	"github.com/BurntSushi/toml"
-	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	. "github.com/yu-org/yu/core/keypair"
 )
 
 type PoaConfig struct {
 	KeyType string `toml:"key_type"`
-	// secret for generating keypair.
-	MySecret   string           `toml:"my_secret"`
+	// secret for generating keypair. It is plaintext, use Keystore in production.
+	MySecret string `toml:"my_secret"`
+	// the encrypted keystore file of the local key, MySecret must be empty if it is set.
+	Keystore string `toml:"keystore"`
+	// where the passphrase of Keystore is read, the file is used first if it is set.
+	// default env is POA_KEYSTORE_PASSPHRASE.
+	PassphraseEnv  string `toml:"passphrase_env"`
+	PassphraseFile string `toml:"passphrase_file"`
+	// refuse to start if the local key or any validator uses the DefaultSecrets.
+	Production bool `toml:"production"`
+	// the unix socket of the remote signer (poasigner) holding the local key,
+	// MySecret and Keystore must be empty if it is set.
+	RemoteSigner string `toml:"remote_signer"`
+	// the height on which the last signed block is forgotten at start, so the validator can sign another block on it.
+	// Set it only if the signed block was never published, otherwise the validator double-signs.
+	SignGuardOverride uint64 `toml:"sign_guard_override"`
+
 	Validators []*ValidatorConf `toml:"validators"`
+	// derive the libp2p peer IDs of validators from their ed25519 pubkeys, so they are listed by pubkey alone.
+	// The node should start libp2p with the key set by SetP2pIdentity.
+	KeyPeerID bool `toml:"key_peer_id"`
 	// block out interval, millisecond
 	BlockInterval int `toml:"block_interval"`
-	// the number of packing txns from txpool, default 5000
+	// the unix timestamp (second) the block slots begin from, every height has its slot BlockInterval after the previous one.
+	// It must be the same on all validators to align their slots, so it is required with more than one validator.
+	// A single validator uses its local genesis block time if it is 0.
+	GenesisTimestamp uint64 `toml:"genesis_timestamp"`
+	// the number of blocks in an epoch, the validator changes take effect from the epoch starts only,
+	// and a signed checkpoint of the validators is written on each epoch end. 0 means no epochs.
+	EpochLength uint64 `toml:"epoch_length"`
+	// the number of recent blocks the slots of every validator are counted in, 0 means no liveness tracking.
+	LivenessWindow uint64 `toml:"liveness_window"`
+	// a validator missing so many slots in the liveness window is jailed: it is skipped by the leader schedule
+	// from the next epoch on, until it sends an Unjail txn. 0 means never jail.
+	JailThreshold uint64 `toml:"jail_threshold"`
+	// how long to wait for the leader of a round before moving to the next round, millisecond.
+	// default is BlockInterval.
+	RoundTimeout int `toml:"round_timeout"`
+	// the max number of txns packed from the txpool into a block, DefaultCfg sets 30000
 	PackNum uint64 `toml:"pack_num"`
+	// the max total size of the encoded txns in a block, byte. 0 means no limit.
+	MaxBlockSize uint64 `toml:"max_block_size"`
+	// the max total execution cost of the txns in a block, costed by the TxnCostFn of Poa. 0 means no limit.
+	MaxBlockCost uint64 `toml:"max_block_cost"`
+	// how the txns are ordered in a block without MEVless, "fifo", "tip" or "nonce". default is fifo.
+	// It must be the same on all validators, they reject the blocks out of the order.
+	TxnOrder string `toml:"txn_order"`
+	// how the leader of each height is elected, "round_robin" or "beacon". default is round_robin.
+	LeaderElection string `toml:"leader_election"`
+	// how far a block timestamp can be ahead of the local clock, second. default 10.
+	MaxClockDrift uint64 `toml:"max_clock_drift"`
+
+	// the checks on txns before they enter the txpool
+	TxnCheck TxnCheckConf `toml:"txn_check"`
+
+	// stop producing and voting blocks when the local state root is in the minority.
+	HaltOnStateDivergence bool `toml:"halt_on_state_divergence"`
+
+	// the local database of poa, stores finality certificates etc.
+	DbPath string `toml:"db_path"`
 
 	PrettyLog bool `toml:"pretty_log"`
 }
 
+const (
+	DefaultDbPath        = "yu/poa"
+	DefaultMaxClockDrift = 10
+	DefaultMaxTxnSize    = 1 << 20
+	// 2024-01-01 00:00:00 UTC
+	DefaultGenesisTimestamp = 1704067200
+)
+
+const (
+	// the leaders follow the weighted schedule of the validators, anyone can predict them.
+	RoundRobinElection = "round_robin"
+	// the leader of a height is drawn by the seed from the signature of its parent block,
+	// so the others learn it only when the parent is produced.
+	// It is not unbiased: the parent miner can try other contents of its block to pick the next leader.
+	BeaconElection = "beacon"
+)
+
 func LoadCfgFromPath(path string) *PoaConfig {
 	cfg := new(PoaConfig)
 	_, err := toml.DecodeFile(path, cfg)
 	if err != nil {
-		logrus.Fatal("load poa-config file (%s) failed: %v", path, err)
+		logrus.Fatalf("load poa-config file (%s) failed: %v", path, err)
 	}
 	return cfg
 }
@@ -44,9 +114,17 @@ func DefaultCfg(idx int) *PoaConfig {
 			{Pubkey: "", P2pIp: "12D3KooWSKPs95miv8wzj3fa5HkJ1tH7oEGumsEiD92n2MYwRtQG"},
 			{Pubkey: "", P2pIp: "12D3KooWRuwP7nXaRhZrmoFJvPPGat2xPafVmGpQpZs5zKMtwqPH"},
 		},
-		BlockInterval: 3000,
-		PackNum:       30000,
-		PrettyLog:     true,
+		BlockInterval:    3000,
+		GenesisTimestamp: DefaultGenesisTimestamp,
+		PackNum:          30000,
+		TxnOrder:         FifoOrder,
+		LeaderElection:   RoundRobinElection,
+		MaxClockDrift:    DefaultMaxClockDrift,
+		TxnCheck: TxnCheckConf{
+			MaxTxnSize: DefaultMaxTxnSize,
+		},
+		DbPath:    DefaultDbPath,
+		PrettyLog: true,
 	}
 	var myPubkey PubKey
 	for i, secret := range DefaultSecrets {
@@ -61,36 +139,157 @@ func DefaultCfg(idx int) *PoaConfig {
 	return cfg
 }
 
+type TxnCheckConf struct {
+	// verify the txn signature by the key type of its pubkey, including ethereum secp256k1 keys.
+	Signature bool `toml:"signature"`
+	// reject the txn whose nonce is used by its sender, the nonce is in the json params.
+	// A pending txn in the pool is replaced by the txn of the same nonce with higher tips.
+	// The committed nonces are kept in the state, so it must be the same on all validators.
+	Nonce bool `toml:"nonce"`
+	// reject the oversized txns and the ones missing the call.
+	Payload bool `toml:"payload"`
+	// the max size of an encoded txn, byte. default 1MB.
+	MaxTxnSize int `toml:"max_txn_size"`
+}
+
 type ValidatorConf struct {
 	Pubkey string `toml:"pubkey"`
-	P2pIp  string `toml:"p2p_ip"`
+	// the libp2p peer ID of the validator, it can be empty with KeyPeerID.
+	// For an ed25519 validator it must be the one derived from its pubkey, see SetP2pIdentity.
+	P2pIp string `toml:"p2p_ip"`
+	// the share of proposing slots, default 1.
+	Weight uint64 `toml:"weight"`
 }
 
-func resolveConfig(cfg *PoaConfig) (PubKey, PrivKey, []ValidatorInfo, error) {
-	pub, priv, err := GenKeyPairWithSecret(cfg.KeyType, []byte(cfg.MySecret))
+func resolveConfig(cfg *PoaConfig) (Signer, []ValidatorInfo, error) {
+	switch cfg.LeaderElection {
+	case "":
+		cfg.LeaderElection = RoundRobinElection
+	case RoundRobinElection, BeaconElection:
+	default:
+		return nil, nil, errors.Errorf("unknown leader election(%s)", cfg.LeaderElection)
+	}
+	switch cfg.TxnOrder {
+	case "":
+		cfg.TxnOrder = FifoOrder
+	case FifoOrder, TipOrder, NonceOrder:
+	default:
+		return nil, nil, errors.Errorf("unknown txn order(%s)", cfg.TxnOrder)
+	}
+	if cfg.TxnCheck.MaxTxnSize == 0 {
+		cfg.TxnCheck.MaxTxnSize = DefaultMaxTxnSize
+	}
+	if cfg.MaxBlockSize > 0 && cfg.TxnCheck.Payload && uint64(cfg.TxnCheck.MaxTxnSize) > cfg.MaxBlockSize {
+		return nil, nil, errors.Errorf("max_txn_size(%d) is larger than max_block_size(%d)",
+			cfg.TxnCheck.MaxTxnSize, cfg.MaxBlockSize)
+	}
+	if cfg.JailThreshold > cfg.LivenessWindow {
+		return nil, nil, errors.Errorf("jail_threshold(%d) is larger than liveness_window(%d)",
+			cfg.JailThreshold, cfg.LivenessWindow)
+	}
+	signer, err := LoadSigner(cfg)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, err
+	}
+	infos, err := ResolveValidators(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(infos) == 0 {
+		return nil, nil, errors.New("no validators in config")
 	}
+	// the genesis blocks are stamped by the local clocks, they cannot align the slots of many validators
+	if len(infos) > 1 && cfg.GenesisTimestamp == 0 {
+		return nil, nil, errors.New("genesis_timestamp is required with more than one validator")
+	}
+	return signer, infos, nil
+}
+
+// ResolveValidators parses the validators in config.
+// With KeyPeerID, the peer IDs come from the pubkeys and must match the configured ones,
+// so must the configured peer IDs of the ed25519 validators without it.
+func ResolveValidators(cfg *PoaConfig) ([]ValidatorInfo, error) {
 	infos := make([]ValidatorInfo, 0)
 	for _, validator := range cfg.Validators {
 		pubkey, err := PubkeyFromStr(validator.Pubkey)
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, err
+		}
+		if validator.Weight > MaxValidatorWeight {
+			return nil, errors.Errorf("weight(%d) of validator(%s) is larger than %d",
+				validator.Weight, validator.Pubkey, MaxValidatorWeight)
+		}
+		peerID, err := resolvePeerID(pubkey, validator.P2pIp, cfg.KeyPeerID)
+		if err != nil {
+			return nil, err
 		}
-		if validator.P2pIp == "" {
-			infos = append(infos, ValidatorInfo{
-				Pubkey: pubkey,
-			})
-		} else {
-			peerID, err := peer.Decode(validator.P2pIp)
+		infos = append(infos, ValidatorInfo{
+			Pubkey: pubkey,
+			P2pID:  peerID,
+			Weight: validator.Weight,
+		})
+	}
+	return infos, nil
+}
+
+// LoadSigner connects the remote signer if it is set, otherwise it signs by the local key.
+func LoadSigner(cfg *PoaConfig) (Signer, error) {
+	if cfg.RemoteSigner == "" {
+		pub, priv, err := LoadKeyPair(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return NewLocalSigner(pub, priv), nil
+	}
+	if cfg.MySecret != "" || cfg.Keystore != "" {
+		return nil, errors.New("my_secret and keystore must be empty when remote_signer is set")
+	}
+	if cfg.Production {
+		// the secret is held by the remote signer, only the validators are checked
+		err := checkProduction(cfg.KeyType, nil, cfg.Validators)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return DialRemoteSigner(cfg.RemoteSigner)
+}
+
+// LoadKeyPair generates the local key from MySecret or the secret in Keystore.
+func LoadKeyPair(cfg *PoaConfig) (PubKey, PrivKey, error) {
+	if cfg.Keystore == "" {
+		if cfg.Production {
+			err := checkProduction(cfg.KeyType, []byte(cfg.MySecret), cfg.Validators)
 			if err != nil {
-				return nil, nil, nil, err
+				return nil, nil, err
 			}
-			infos = append(infos, ValidatorInfo{
-				Pubkey: pubkey,
-				P2pID:  peerID,
-			})
+		}
+		return GenKeyPairWithSecret(cfg.KeyType, []byte(cfg.MySecret))
+	}
+
+	if cfg.MySecret != "" {
+		return nil, nil, errors.New("my_secret and keystore cannot be both set")
+	}
+	ks, err := LoadKeystore(cfg.Keystore)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.KeyType != "" && cfg.KeyType != ks.KeyType {
+		return nil, nil, errors.Errorf("key type(%s) mismatches the keystore(%s)", cfg.KeyType, ks.KeyType)
+	}
+	cfg.KeyType = ks.KeyType
+	passphrase, err := ReadPassphrase(cfg.PassphraseEnv, cfg.PassphraseFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := ks.Decrypt(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.Production {
+		err = checkProduction(ks.KeyType, secret, cfg.Validators)
+		if err != nil {
+			return nil, nil, err
 		}
 	}
-	return pub, priv, infos, nil
+	return ks.keyPair(secret)
 }
@@ -0,0 +1,100 @@
+package poa
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/types"
+)
+
+// LeaderOf returns the validator who proposes the block of height on top of prevHash in round.
+func (h *Poa) LeaderOf(height common.BlockNum, prevHash common.Hash, round uint64) (common.Address, error) {
+	var parent *types.Header
+	if h.cfg.LeaderElection == BeaconElection {
+		block, err := h.Chain.GetCompactBlock(prevHash)
+		if err != nil {
+			return common.Address{}, err
+		}
+		parent = block.Header
+	}
+	return ElectLeader(h.LeadersAt(height), h.cfg.LeaderElection, height, parent, round), nil
+}
+
+// ElectLeader returns the leader of height in round among validators, parent is only read by the beacon election.
+// Round 0 is the proposer of the election, the next rounds are backup leaders
+// following it in the validators list.
+func ElectLeader(validators *ValidatorSet, election string, height common.BlockNum, parent *types.Header, round uint64) common.Address {
+	var leader common.Address
+	if election == BeaconElection {
+		leader = validators.ProposerBySeed(Beacon(parent))
+	} else {
+		leader = validators.Proposer(height)
+	}
+	if round == 0 {
+		return leader
+	}
+	idx := (uint64(validators.Index(leader)) + round) % uint64(validators.Len())
+	return validators.Addrs[idx]
+}
+
+// Beacon is the seed to elect the leader of the child of parent.
+// It comes from the signature of the parent miner, which the others cannot compute in advance.
+// But the parent miner can sign other versions of its block and keep the one electing the leader it likes.
+func Beacon(parent *types.Header) []byte {
+	if parent.Height == 0 {
+		// genesis is signed by every node locally, only its hash is the same.
+		return common.Sha256(parent.Hash.Bytes())
+	}
+	return common.Sha256(parent.MinerSignature)
+}
+
+func (h *Poa) CompeteLeader(blockHeight common.BlockNum) common.Address {
+	var prevHash common.Hash
+	if h.cfg.LeaderElection == BeaconElection {
+		var err error
+		prevHash, err = h.chainHashAt(blockHeight - 1)
+		if err != nil {
+			logrus.Warnf("the leader of height(%d) is unknown until its parent is produced: %v", blockHeight, err)
+			return common.Address{}
+		}
+	}
+	leader, err := h.LeaderOf(blockHeight, prevHash, 0)
+	if err != nil {
+		logrus.Errorf("compete leader of height(%d) failed: %v", blockHeight, err)
+		return common.Address{}
+	}
+	logrus.Debugf("compete a leader(%s) in round(%d)", leader.String(), blockHeight)
+	return leader
+}
+
+// chainHashAt returns the hash of the block on height in the local chain, which other blocks of the height
+// stored locally are not on. It is the chain end or a finalized block, or found by walking down from the chain end.
+func (h *Poa) chainHashAt(height common.BlockNum) (common.Hash, error) {
+	end, err := h.Chain.GetEndCompactBlock()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if height > end.Height {
+		return common.Hash{}, errors.Errorf("height(%d) is above the chain end(%d)", height, end.Height)
+	}
+	if height == end.Height {
+		return end.Hash, nil
+	}
+	finalized, err := h.Chain.LastFinalizedCompact()
+	if err == nil && height <= finalized.Height {
+		block, err := h.Chain.GetCompactBlockByHeight(height)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		return block.Hash, nil
+	}
+	hash := end.PrevHash
+	for hei := end.Height - 1; hei > height; hei-- {
+		block, err := h.Chain.GetCompactBlock(hash)
+		if err != nil {
+			return common.Hash{}, err
+		}
+		hash = block.PrevHash
+	}
+	return hash, nil
+}
@@ -0,0 +1,262 @@
+package poa
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/cockroachdb/pebble"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/context"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"net/http"
+)
+
+var checkpointPrefix = []byte("checkpoint_")
+
+// EpochHook is called after the last block of an epoch is executed, next is the checkpoint of the epoch after it.
+// It runs in the block lifecycle, so it must return soon.
+type EpochHook func(block *types.Block, next *Checkpoint)
+
+// OnEpochEnd registers a hook on the epoch boundaries, it must be called before the chain starts.
+func (h *Poa) OnEpochEnd(hook EpochHook) {
+	h.epochHooks = append(h.epochHooks, hook)
+}
+
+// EpochOf returns the epoch of height. Epoch e has the heights from e*EpochLength+1 to (e+1)*EpochLength,
+// the genesis block is in epoch 0.
+func (h *Poa) EpochOf(height common.BlockNum) uint64 {
+	if h.cfg.EpochLength == 0 || height == 0 {
+		return 0
+	}
+	return (uint64(height) - 1) / h.cfg.EpochLength
+}
+
+// IsEpochEnd tells if height is the last block of an epoch.
+func (h *Poa) IsEpochEnd(height common.BlockNum) bool {
+	return h.cfg.EpochLength > 0 && height > 0 && uint64(height)%h.cfg.EpochLength == 0
+}
+
+func (h *Poa) isEpochStart(height common.BlockNum) bool {
+	return h.cfg.EpochLength == 0 || (height > 0 && (uint64(height)-1)%h.cfg.EpochLength == 0)
+}
+
+// nextEpochStart returns the first height of the epoch after the one of height, or the next height without epochs.
+func (h *Poa) nextEpochStart(height common.BlockNum) common.BlockNum {
+	if h.cfg.EpochLength == 0 {
+		return height + 1
+	}
+	return common.BlockNum((h.EpochOf(height)+1)*h.cfg.EpochLength + 1)
+}
+
+// CheckpointValidator is a validator in the checkpoint.
+type CheckpointValidator struct {
+	Pubkey string `json:"pubkey"`
+	P2pID  string `json:"p2p_id,omitempty"`
+	Weight uint64 `json:"weight,omitempty"`
+}
+
+// Checkpoint is the validator set of an epoch, fixed by the last block of the epoch before it.
+type Checkpoint struct {
+	Epoch uint64 `json:"epoch"`
+	// the last block of the previous epoch
+	Height     common.BlockNum        `json:"height"`
+	BlockHash  common.Hash            `json:"block_hash"`
+	Validators []*CheckpointValidator `json:"validators"`
+	// the validators skipped by the leader schedule of the epoch
+	Jailed []common.Address `json:"jailed,omitempty"`
+}
+
+func (c *Checkpoint) Hash() common.Hash {
+	byt, _ := json.Marshal(c)
+	return common.BytesToHash(common.Sha256(byt))
+}
+
+// ValidatorSet returns the validators of the checkpoint, which work from the height after it.
+func (c *Checkpoint) ValidatorSet() (*ValidatorSet, error) {
+	infos := make([]ValidatorInfo, 0, len(c.Validators))
+	for _, validator := range c.Validators {
+		pubkey, err := keypair.PubkeyFromStr(validator.Pubkey)
+		if err != nil {
+			return nil, err
+		}
+		info := ValidatorInfo{Pubkey: pubkey, Weight: validator.Weight}
+		if validator.P2pID != "" {
+			info.P2pID, err = peer.Decode(validator.P2pID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		infos = append(infos, info)
+	}
+	return NewValidatorSet(c.Height+1, infos), nil
+}
+
+// LeaderSet returns the validators of the checkpoint who lead the rounds, the jailed ones are skipped.
+func (c *Checkpoint) LeaderSet() (*ValidatorSet, error) {
+	set, err := c.ValidatorSet()
+	if err != nil {
+		return nil, err
+	}
+	return set.Exclude(c.Jailed), nil
+}
+
+// SignedCheckpoint carries the votes of more than 2/3 validators of the previous epoch,
+// which vote for the block of the checkpoint together with its hash.
+type SignedCheckpoint struct {
+	*Checkpoint
+	Votes []*Vote `json:"votes"`
+}
+
+func (c *SignedCheckpoint) Encode() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func DecodeSignedCheckpoint(byt []byte) (*SignedCheckpoint, error) {
+	c := new(SignedCheckpoint)
+	err := json.Unmarshal(byt, c)
+	return c, err
+}
+
+// Verify checks the checkpoint is signed by the validators of the previous epoch.
+func (c *SignedCheckpoint) Verify(validators *ValidatorSet) error {
+	hash := c.Hash()
+	signed := make(map[common.Address]struct{})
+	for _, vote := range c.Votes {
+		if vote.Height != c.Height || vote.BlockHash != c.BlockHash || vote.Checkpoint == nil || *vote.Checkpoint != hash {
+			return errors.Errorf("vote for block(%s) height(%d) mismatches the checkpoint of epoch(%d)",
+				vote.BlockHash.String(), vote.Height, c.Epoch)
+		}
+		voter, err := vote.Voter()
+		if err != nil {
+			return err
+		}
+		if !validators.Contains(voter) {
+			return errors.Errorf("voter(%s) is not validator", voter.String())
+		}
+		signed[voter] = struct{}{}
+	}
+	if len(signed) < quorum(validators.Len()) {
+		return errors.Errorf("checkpoint of epoch(%d) only has %d votes, needs %d",
+			c.Epoch, len(signed), quorum(validators.Len()))
+	}
+	return nil
+}
+
+// newCheckpoint makes the checkpoint on the last block of an epoch, from the executed validator changes.
+func (h *Poa) newCheckpoint(height common.BlockNum, blockHash common.Hash) *Checkpoint {
+	set := h.ValidatorSetAt(height + 1)
+	cp := &Checkpoint{
+		Epoch:      h.EpochOf(height + 1),
+		Height:     height,
+		BlockHash:  blockHash,
+		Validators: make([]*CheckpointValidator, 0, set.Len()),
+	}
+	for _, addr := range set.Addrs {
+		info := set.Infos[addr]
+		validator := &CheckpointValidator{
+			Pubkey: info.Pubkey.StringWithType(),
+			Weight: info.Weight,
+		}
+		if info.P2pID != "" {
+			validator.P2pID = info.P2pID.String()
+		}
+		cp.Validators = append(cp.Validators, validator)
+	}
+	leaders := h.LeadersAt(height + 1)
+	for _, addr := range set.Addrs {
+		if !leaders.Contains(addr) {
+			cp.Jailed = append(cp.Jailed, addr)
+		}
+	}
+	return cp
+}
+
+// endEpoch runs the epoch hooks if the executed block ends an epoch.
+func (h *Poa) endEpoch(block *types.Block) {
+	if !h.IsEpochEnd(block.Height) {
+		return
+	}
+	next := h.newCheckpoint(block.Height, block.Hash)
+	logrus.Infof("epoch(%d) ends on block(%d), %d validators in the next epoch",
+		next.Epoch-1, block.Height, len(next.Validators))
+	for _, hook := range h.epochHooks {
+		hook(block, next)
+	}
+}
+
+// saveCheckpoint picks the votes signing the checkpoint from the finality certificate of an epoch end.
+func (h *Poa) saveCheckpoint(cert *FinalityCert) {
+	if !h.IsEpochEnd(cert.Height) {
+		return
+	}
+	signed := &SignedCheckpoint{Checkpoint: h.newCheckpoint(cert.Height, cert.BlockHash)}
+	hash := signed.Hash()
+	for _, vote := range cert.Votes {
+		if vote.Checkpoint != nil && *vote.Checkpoint == hash {
+			signed.Votes = append(signed.Votes, vote)
+		}
+	}
+	err := signed.Verify(h.ValidatorSetAt(cert.Height))
+	if err != nil {
+		logrus.Errorf("checkpoint of epoch(%d) is not signed: %v", signed.Epoch, err)
+		return
+	}
+	err = h.storeCheckpoint(signed)
+	if err != nil {
+		logrus.Errorf("store checkpoint of epoch(%d) failed: %v", signed.Epoch, err)
+	}
+}
+
+func checkpointKey(epoch uint64) []byte {
+	key := make([]byte, len(checkpointPrefix)+8)
+	copy(key, checkpointPrefix)
+	binary.BigEndian.PutUint64(key[len(checkpointPrefix):], epoch)
+	return key
+}
+
+func (h *Poa) storeCheckpoint(cp *SignedCheckpoint) error {
+	byt, err := cp.Encode()
+	if err != nil {
+		return err
+	}
+	return h.db.Set(checkpointKey(cp.Epoch), byt, pebble.Sync)
+}
+
+// GetCheckpoint returns the signed checkpoint of epoch, nil if it is not finalized yet.
+func (h *Poa) GetCheckpoint(epoch uint64) (*SignedCheckpoint, error) {
+	byt, closer, err := h.db.Get(checkpointKey(epoch))
+	if err == pebble.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return DecodeSignedCheckpoint(byt)
+}
+
+type EpochRequest struct {
+	Epoch uint64 `json:"epoch"`
+}
+
+func (h *Poa) QueryCheckpoint(ctx *context.ReadContext) {
+	var req EpochRequest
+	err := ctx.BindJson(&req)
+	if err != nil {
+		ctx.Err(http.StatusBadRequest, err)
+		return
+	}
+	cp, err := h.GetCheckpoint(req.Epoch)
+	if err != nil {
+		ctx.ErrOk(err)
+		return
+	}
+	if cp == nil {
+		ctx.ErrOk(errors.Errorf("checkpoint of epoch(%d) is not signed", req.Epoch))
+		return
+	}
+	ctx.JsonOk(cp)
+}
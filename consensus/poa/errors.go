@@ -0,0 +1,264 @@
+package poa
+
+import (
+	"fmt"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/types"
+)
+
+type ErrMinerNotValidator struct {
+	Miner  common.Address
+	Height common.BlockNum
+}
+
+func MinerNotValidator(miner common.Address, height common.BlockNum) ErrMinerNotValidator {
+	return ErrMinerNotValidator{Miner: miner, Height: height}
+}
+
+func (e ErrMinerNotValidator) Error() string {
+	return fmt.Sprintf("miner(%s) is not validator on height(%d)", e.Miner.String(), e.Height)
+}
+
+type ErrMinerNotLeader struct {
+	Miner  common.Address
+	Leader common.Address
+	Height common.BlockNum
+	Round  uint64
+}
+
+func MinerNotLeader(miner, leader common.Address, height common.BlockNum, round uint64) ErrMinerNotLeader {
+	return ErrMinerNotLeader{Miner: miner, Leader: leader, Height: height, Round: round}
+}
+
+func (e ErrMinerNotLeader) Error() string {
+	return fmt.Sprintf("miner(%s) is not the leader(%s) of height(%d) round(%d)",
+		e.Miner.String(), e.Leader.String(), e.Height, e.Round)
+}
+
+type ErrBlockHashMismatch struct {
+	BlockHash common.Hash
+	Computed  common.Hash
+}
+
+func BlockHashMismatch(blockHash, computed common.Hash) ErrBlockHashMismatch {
+	return ErrBlockHashMismatch{BlockHash: blockHash, Computed: computed}
+}
+
+func (e ErrBlockHashMismatch) Error() string {
+	return fmt.Sprintf("block hash(%s) mismatches its header(%s)", e.BlockHash.String(), e.Computed.String())
+}
+
+type ErrTxnHashMismatch struct {
+	TxnHash  common.Hash
+	Computed common.Hash
+}
+
+func TxnHashMismatch(txnHash, computed common.Hash) ErrTxnHashMismatch {
+	return ErrTxnHashMismatch{TxnHash: txnHash, Computed: computed}
+}
+
+func (e ErrTxnHashMismatch) Error() string {
+	return fmt.Sprintf("txn hash(%s) mismatches its content(%s)", e.TxnHash.String(), e.Computed.String())
+}
+
+type ErrTxnRootMismatch struct {
+	BlockHash common.Hash
+	TxnRoot   common.Hash
+	Computed  common.Hash
+}
+
+func TxnRootMismatch(blockHash, txnRoot, computed common.Hash) ErrTxnRootMismatch {
+	return ErrTxnRootMismatch{BlockHash: blockHash, TxnRoot: txnRoot, Computed: computed}
+}
+
+func (e ErrTxnRootMismatch) Error() string {
+	return fmt.Sprintf("txn-root(%s) of block(%s) mismatches its txns(%s)", e.TxnRoot.String(), e.BlockHash.String(), e.Computed.String())
+}
+
+type ErrParentNotFound struct {
+	BlockHash common.Hash
+	PrevHash  common.Hash
+}
+
+func ParentNotFound(blockHash, prevHash common.Hash) ErrParentNotFound {
+	return ErrParentNotFound{BlockHash: blockHash, PrevHash: prevHash}
+}
+
+func (e ErrParentNotFound) Error() string {
+	return fmt.Sprintf("parent(%s) of block(%s) not found", e.PrevHash.String(), e.BlockHash.String())
+}
+
+type ErrHeightNotContinuous struct {
+	BlockHash    common.Hash
+	Height       common.BlockNum
+	ParentHeight common.BlockNum
+}
+
+func HeightNotContinuous(blockHash common.Hash, height, parentHeight common.BlockNum) ErrHeightNotContinuous {
+	return ErrHeightNotContinuous{BlockHash: blockHash, Height: height, ParentHeight: parentHeight}
+}
+
+func (e ErrHeightNotContinuous) Error() string {
+	return fmt.Sprintf("height(%d) of block(%s) does not follow its parent height(%d)",
+		e.Height, e.BlockHash.String(), e.ParentHeight)
+}
+
+type ErrTimestampOutOfRange struct {
+	BlockHash common.Hash
+	Timestamp uint64
+	Min       uint64
+	Max       uint64
+}
+
+func TimestampOutOfRange(blockHash common.Hash, timestamp, min, max uint64) ErrTimestampOutOfRange {
+	return ErrTimestampOutOfRange{BlockHash: blockHash, Timestamp: timestamp, Min: min, Max: max}
+}
+
+func (e ErrTimestampOutOfRange) Error() string {
+	return fmt.Sprintf("timestamp(%d) of block(%s) is out of range [%d, %d]",
+		e.Timestamp, e.BlockHash.String(), e.Min, e.Max)
+}
+
+type ErrBlockOverLimit struct {
+	BlockHash common.Hash
+	// BlockSizeLimit or BlockCostLimit
+	Limit string
+	Used  uint64
+	Max   uint64
+}
+
+func BlockOverLimit(blockHash common.Hash, limit string, used, max uint64) ErrBlockOverLimit {
+	return ErrBlockOverLimit{BlockHash: blockHash, Limit: limit, Used: used, Max: max}
+}
+
+func (e ErrBlockOverLimit) Error() string {
+	return fmt.Sprintf("txns of block(%s) use %s %d, over the limit %d", e.BlockHash.String(), e.Limit, e.Used, e.Max)
+}
+
+type ErrTxnOrderViolated struct {
+	BlockHash common.Hash
+	Order     string
+	// the first txn out of the order
+	TxnHash common.Hash
+}
+
+func TxnOrderViolated(blockHash common.Hash, order string, txnHash common.Hash) ErrTxnOrderViolated {
+	return ErrTxnOrderViolated{BlockHash: blockHash, Order: order, TxnHash: txnHash}
+}
+
+func (e ErrTxnOrderViolated) Error() string {
+	return fmt.Sprintf("txn(%s) of block(%s) breaks the %s order", e.TxnHash.String(), e.BlockHash.String(), e.Order)
+}
+
+type ErrBlockFailed struct {
+	Height common.BlockNum
+	Round  uint64
+	Stage  string
+	// RetryableFailure, SkipSlotFailure or FatalFailure
+	Class string
+	Err   error
+}
+
+func BlockFailed(block *types.Block, stage, class string, err error) ErrBlockFailed {
+	return ErrBlockFailed{Height: block.Height, Round: BlockRound(block), Stage: stage, Class: class, Err: err}
+}
+
+func (e ErrBlockFailed) Error() string {
+	return fmt.Sprintf("%s failure at %s of block(%d) round(%d): %v", e.Class, e.Stage, e.Height, e.Round, e.Err)
+}
+
+func (e ErrBlockFailed) Unwrap() error {
+	return e.Err
+}
+
+type ErrStaleBlock struct {
+	BlockHash common.Hash
+	Height    common.BlockNum
+	End       common.BlockNum
+}
+
+func StaleBlock(blockHash common.Hash, height, end common.BlockNum) ErrStaleBlock {
+	return ErrStaleBlock{BlockHash: blockHash, Height: height, End: end}
+}
+
+func (e ErrStaleBlock) Error() string {
+	return fmt.Sprintf("block(%s) of height(%d) is stale, the chain has reached height(%d)",
+		e.BlockHash.String(), e.Height, e.End)
+}
+
+type ErrBlockTooFarAhead struct {
+	BlockHash common.Hash
+	Height    common.BlockNum
+	End       common.BlockNum
+}
+
+func BlockTooFarAhead(blockHash common.Hash, height, end common.BlockNum) ErrBlockTooFarAhead {
+	return ErrBlockTooFarAhead{BlockHash: blockHash, Height: height, End: end}
+}
+
+func (e ErrBlockTooFarAhead) Error() string {
+	return fmt.Sprintf("block(%s) of height(%d) is too far ahead of the chain height(%d)",
+		e.BlockHash.String(), e.Height, e.End)
+}
+
+type ErrDuplicateBlock struct {
+	BlockHash common.Hash
+	Height    common.BlockNum
+}
+
+func DuplicateBlock(blockHash common.Hash, height common.BlockNum) ErrDuplicateBlock {
+	return ErrDuplicateBlock{BlockHash: blockHash, Height: height}
+}
+
+func (e ErrDuplicateBlock) Error() string {
+	return fmt.Sprintf("block(%s) of height(%d) is received already", e.BlockHash.String(), e.Height)
+}
+
+// ErrTxnRejected is returned when a txn fails one of the admission checks.
+type ErrTxnRejected struct {
+	TxnHash common.Hash
+	// name of the failed check
+	Check  string
+	Reason string
+}
+
+func TxnRejected(txnHash common.Hash, check, reason string) ErrTxnRejected {
+	return ErrTxnRejected{TxnHash: txnHash, Check: check, Reason: reason}
+}
+
+func (e ErrTxnRejected) Error() string {
+	return fmt.Sprintf("txn(%s) is rejected by %s check: %s", e.TxnHash.String(), e.Check, e.Reason)
+}
+
+// ErrSignRefused is returned by the signer when signing the message would sign twice on one height and round,
+// or sign below the highest one it has signed. Kind is "block", "vote" or "round-change".
+type ErrSignRefused struct {
+	Kind         string
+	Height       common.BlockNum
+	Round        uint64
+	SignedHeight common.BlockNum
+	SignedRound  uint64
+}
+
+func SignRefused(kind string, height common.BlockNum, round uint64, signedHeight common.BlockNum, signedRound uint64) ErrSignRefused {
+	return ErrSignRefused{Kind: kind, Height: height, Round: round, SignedHeight: signedHeight, SignedRound: signedRound}
+}
+
+func (e ErrSignRefused) Error() string {
+	return fmt.Sprintf("refuse to sign %s of height(%d) round(%d), signed height(%d) round(%d) already",
+		e.Kind, e.Height, e.Round, e.SignedHeight, e.SignedRound)
+}
+
+// ErrValidatorNotJailed is returned when a validator which is not jailed asks to be unjailed.
+type ErrValidatorNotJailed struct {
+	Validator common.Address
+	Height    common.BlockNum
+}
+
+func ValidatorNotJailed(validator common.Address, height common.BlockNum) ErrValidatorNotJailed {
+	return ErrValidatorNotJailed{Validator: validator, Height: height}
+}
+
+func (e ErrValidatorNotJailed) Error() string {
+	return fmt.Sprintf("validator(%s) is not jailed on height(%d)", e.Validator.String(), e.Height)
+}
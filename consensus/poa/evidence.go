@@ -0,0 +1,303 @@
+package poa
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/cockroachdb/pebble"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/context"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"net/http"
+	"sync"
+)
+
+const EvidenceTopic = "poa-evidence"
+
+// how many heights of signed headers are kept to find double-signs
+const evidenceWindow = 256
+
+var evidencePrefix = []byte("evidence_")
+
+// DoubleSignEvidence proves that a validator signed two different blocks for the same height and round.
+// Anyone who knows the validator set of the height can check it.
+type DoubleSignEvidence struct {
+	HeaderA *types.Header `json:"header_a"`
+	HeaderB *types.Header `json:"header_b"`
+}
+
+func (e *DoubleSignEvidence) Height() common.BlockNum {
+	return e.HeaderA.Height
+}
+
+func (e *DoubleSignEvidence) Round() uint64 {
+	return e.HeaderA.Nonce
+}
+
+func (e *DoubleSignEvidence) Encode() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+func DecodeDoubleSignEvidence(byt []byte) (*DoubleSignEvidence, error) {
+	e := new(DoubleSignEvidence)
+	err := json.Unmarshal(byt, e)
+	return e, err
+}
+
+// Verify checks that the two headers conflict and are both signed by the same miner,
+// and returns the address of the offender.
+func (e *DoubleSignEvidence) Verify() (common.Address, error) {
+	a, b := e.HeaderA, e.HeaderB
+	if a == nil || b == nil {
+		return common.Address{}, errors.New("evidence needs two headers")
+	}
+	if a.Height != b.Height || a.Nonce != b.Nonce {
+		return common.Address{}, errors.Errorf("headers of height(%d) round(%d) and height(%d) round(%d) do not conflict",
+			a.Height, a.Nonce, b.Height, b.Nonce)
+	}
+	if a.Hash == b.Hash {
+		return common.Address{}, errors.Errorf("headers are the same block(%s)", a.Hash.String())
+	}
+	minerA, err := signedMiner(a)
+	if err != nil {
+		return common.Address{}, err
+	}
+	minerB, err := signedMiner(b)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if minerA != minerB {
+		return common.Address{}, errors.Errorf("headers are signed by different miners(%s, %s)", minerA.String(), minerB.String())
+	}
+	return minerA, nil
+}
+
+// signedMiner checks the hash and signature of the header and returns its miner.
+func signedMiner(header *types.Header) (common.Address, error) {
+	if hash := HeaderHash(header); hash != header.Hash {
+		return common.Address{}, errors.Errorf("header hash(%s) mismatches its content(%s)", header.Hash.String(), hash.String())
+	}
+	pubkey, err := keypair.PubKeyFromBytes(header.MinerPubkey)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if pubkey == nil {
+		return common.Address{}, errors.New("header has no miner pubkey")
+	}
+	if !pubkey.VerifySignature(header.Hash.Bytes(), header.MinerSignature) {
+		return common.Address{}, errors.Errorf("signature of header(%s) is illegal", header.Hash.String())
+	}
+	return pubkey.Address(), nil
+}
+
+// DoubleSignHandler is called once for each verified evidence,
+// other tripods can use it to punish the offender, such as sending a RemoveValidator txn.
+type DoubleSignHandler func(offender common.Address, evidence *DoubleSignEvidence)
+
+// evidencePool keeps the signed headers seen recently to find the conflicting ones.
+type evidencePool struct {
+	sync.Mutex
+	// key: height, miner, round
+	headers  map[common.BlockNum]map[common.Address]map[uint64]*types.Header
+	handlers []DoubleSignHandler
+}
+
+func newEvidencePool() *evidencePool {
+	return &evidencePool{
+		headers: make(map[common.BlockNum]map[common.Address]map[uint64]*types.Header),
+	}
+}
+
+// add records the header and returns an evidence if the miner has signed another block
+// of the same height and round.
+func (ep *evidencePool) add(miner common.Address, header *types.Header, currentHeight common.BlockNum) *DoubleSignEvidence {
+	ep.Lock()
+	defer ep.Unlock()
+	if header.Height+evidenceWindow < currentHeight || header.Height > currentHeight+evidenceWindow {
+		return nil
+	}
+	for height := range ep.headers {
+		if height+evidenceWindow < currentHeight {
+			delete(ep.headers, height)
+		}
+	}
+
+	miners, ok := ep.headers[header.Height]
+	if !ok {
+		miners = make(map[common.Address]map[uint64]*types.Header)
+		ep.headers[header.Height] = miners
+	}
+	rounds, ok := miners[miner]
+	if !ok {
+		rounds = make(map[uint64]*types.Header)
+		miners[miner] = rounds
+	}
+	seen, ok := rounds[header.Nonce]
+	if !ok {
+		rounds[header.Nonce] = header
+		return nil
+	}
+	if seen.Hash == header.Hash {
+		return nil
+	}
+	return &DoubleSignEvidence{HeaderA: seen, HeaderB: header}
+}
+
+func (ep *evidencePool) addHandler(fn DoubleSignHandler) {
+	ep.Lock()
+	defer ep.Unlock()
+	ep.handlers = append(ep.handlers, fn)
+}
+
+func (ep *evidencePool) getHandlers() []DoubleSignHandler {
+	ep.Lock()
+	defer ep.Unlock()
+	return ep.handlers
+}
+
+// OnDoubleSign registers a handler which is called when a double-sign is found locally or received from other nodes.
+func (h *Poa) OnDoubleSign(fn DoubleSignHandler) {
+	h.evidences.addHandler(fn)
+}
+
+// VerifyEvidence checks the evidence and that the offender is a validator on its height.
+func (h *Poa) VerifyEvidence(evidence *DoubleSignEvidence) (common.Address, error) {
+	offender, err := evidence.Verify()
+	if err != nil {
+		return common.Address{}, err
+	}
+	if !h.ValidatorSetAt(evidence.Height()).Contains(offender) {
+		return common.Address{}, errors.Errorf("offender(%s) is not validator on height(%d)", offender.String(), evidence.Height())
+	}
+	return offender, nil
+}
+
+// checkDoubleSign records the header of a block from p2p, and reports the miner if it conflicts with a seen one.
+func (h *Poa) checkDoubleSign(header *types.Header) {
+	miner, err := signedMiner(header)
+	if err != nil {
+		return
+	}
+	if !h.ValidatorSetAt(header.Height).Contains(miner) {
+		return
+	}
+	evidence := h.evidences.add(miner, header, h.getCurrentHeight())
+	if evidence == nil {
+		return
+	}
+	h.handleEvidence(miner, evidence, true)
+}
+
+func (h *Poa) handleEvidence(offender common.Address, evidence *DoubleSignEvidence, broadcast bool) {
+	byt, ok := h.storeEvidence(offender, evidence)
+	if !ok {
+		return
+	}
+	if broadcast {
+		err := h.P2pNetwork.PubP2P(EvidenceTopic, byt)
+		if err != nil {
+			logrus.Error("broadcast evidence failed: ", err)
+		}
+	}
+	for _, handler := range h.evidences.getHandlers() {
+		handler(offender, evidence)
+	}
+}
+
+// storeEvidence stores the evidence unless it is already known.
+// the check and the store are done under evidenceLock, so only one caller stores it.
+func (h *Poa) storeEvidence(offender common.Address, evidence *DoubleSignEvidence) ([]byte, bool) {
+	h.evidenceLock.Lock()
+	defer h.evidenceLock.Unlock()
+
+	key := evidenceKey(evidence.Height(), evidence.Round(), offender)
+	_, closer, err := h.db.Get(key)
+	if err == nil {
+		closer.Close()
+		return nil, false
+	}
+	if err != pebble.ErrNotFound {
+		logrus.Error("get evidence from db failed: ", err)
+		return nil, false
+	}
+
+	logrus.Warnf("validator(%s) double-signs on height(%d) round(%d): block(%s) and block(%s)",
+		offender.String(), evidence.Height(), evidence.Round(), evidence.HeaderA.Hash.String(), evidence.HeaderB.Hash.String())
+
+	byt, err := evidence.Encode()
+	if err != nil {
+		logrus.Error("encode evidence failed: ", err)
+		return nil, false
+	}
+	err = h.db.Set(key, byt, pebble.Sync)
+	if err != nil {
+		logrus.Error("store evidence failed: ", err)
+		return nil, false
+	}
+	return byt, true
+}
+
+func (h *Poa) handleEvidences() {
+	for {
+		msg, err := h.P2pNetwork.SubP2P(EvidenceTopic)
+		if err != nil {
+			logrus.Error("subscribe evidence from P2P error: ", err)
+			continue
+		}
+		if h.failed.Load() {
+			continue
+		}
+		evidence, err := DecodeDoubleSignEvidence(msg)
+		if err != nil {
+			logrus.Error("decode evidence from p2p error: ", err)
+			continue
+		}
+		offender, err := h.VerifyEvidence(evidence)
+		if err != nil {
+			logrus.Warn("verify evidence failed: ", err)
+			continue
+		}
+		h.handleEvidence(offender, evidence, false)
+	}
+}
+
+func evidenceKey(height common.BlockNum, round uint64, offender common.Address) []byte {
+	key := make([]byte, len(evidencePrefix)+16)
+	copy(key, evidencePrefix)
+	binary.BigEndian.PutUint64(key[len(evidencePrefix):], uint64(height))
+	binary.BigEndian.PutUint64(key[len(evidencePrefix)+8:], round)
+	return append(key, offender.Bytes()...)
+}
+
+// GetEvidences returns all the double-sign evidences this node knows, ascending by height.
+func (h *Poa) GetEvidences() ([]*DoubleSignEvidence, error) {
+	upper := make([]byte, len(evidencePrefix))
+	copy(upper, evidencePrefix)
+	upper[len(upper)-1]++
+	iter, err := h.db.NewIter(&pebble.IterOptions{LowerBound: evidencePrefix, UpperBound: upper})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	evidences := make([]*DoubleSignEvidence, 0)
+	for iter.First(); iter.Valid(); iter.Next() {
+		evidence, err := DecodeDoubleSignEvidence(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		evidences = append(evidences, evidence)
+	}
+	return evidences, iter.Error()
+}
+
+func (h *Poa) QueryEvidences(ctx *context.ReadContext) {
+	evidences, err := h.GetEvidences()
+	if err != nil {
+		ctx.Err(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JsonOk(evidences)
+}
@@ -0,0 +1,199 @@
+package poa
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/cockroachdb/pebble"
+	"github.com/sirupsen/logrus"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/kernel"
+	"github.com/yu-org/yu/core/types"
+	"time"
+)
+
+// the classes of the failures in the block lifecycle
+const (
+	// the failure may go away soon, such as a p2p hiccup. The step is retried with backoff.
+	RetryableFailure = "retryable"
+	// the leader gives up its block of the slot, the leader of the next round takes the height.
+	SkipSlotFailure = "skip_slot"
+	// the local chain may be inconsistent, the node records the failed block and stops.
+	FatalFailure = "fatal"
+)
+
+// the steps of the block lifecycle which can fail
+const (
+	SlotStage      = "slot"
+	PackStage      = "pack"
+	EncodeStage    = "encode"
+	PublishStage   = "publish"
+	ExecuteStage   = "execute"
+	AppendStage    = "append"
+	ResetPoolStage = "reset_pool"
+)
+
+const (
+	retryAttempts = 5
+	// doubled after every failed attempt
+	retryBackoff = 50 * time.Millisecond
+)
+
+var blockFailurePrefix = []byte("block_failure_")
+
+// BlockFailure is the diagnostic record of the block on which the node failed fatally.
+type BlockFailure struct {
+	Height    common.BlockNum `json:"height"`
+	Round     uint64          `json:"round"`
+	BlockHash common.Hash     `json:"block_hash"`
+	Stage     string          `json:"stage"`
+	Error     string          `json:"error"`
+	// unix timestamp, second
+	Time uint64 `json:"time"`
+	// the encoded block, empty if it cannot be encoded
+	Block []byte `json:"block"`
+}
+
+// SetShutdown replaces how the node shuts down after a fatal failure, it sends the error to Fatal by default.
+// fn runs in a goroutine of its own after the failed block is recorded and poa stops producing blocks.
+func (h *Poa) SetShutdown(fn func(err error)) {
+	h.shutdown = fn
+}
+
+// Fatal returns the fatal failure of the node once it happens.
+// The app should stop the kernel and Close poa then, the block lifecycle is parked until Close.
+// StopOnFatal stops the kernel.
+func (h *Poa) Fatal() <-chan error {
+	return h.fatalCh
+}
+
+// StopOnFatal stops k on the fatal failure of the node, as the main of an app does with Fatal,
+// then calls done with the failure. The poa db stays open for the queries on the failure until Close.
+// Nothing happens if poa is closed before.
+func (h *Poa) StopOnFatal(k *kernel.Kernel, done func(err error)) {
+	go func() {
+		var err error
+		select {
+		case err = <-h.fatalCh:
+		case <-h.stopCh:
+			return
+		}
+		// the kernel takes the stop after the parked block loop is released
+		h.release()
+		k.Stop()
+		done(err)
+	}()
+}
+
+func (h *Poa) report(err error) {
+	logrus.Errorf("poa stops: %v", err)
+	select {
+	case h.fatalCh <- err:
+	default:
+	}
+}
+
+// Failed tells if the node has failed fatally and stopped producing blocks.
+func (h *Poa) Failed() bool {
+	return h.failed.Load()
+}
+
+// retry runs fn until it succeeds, or it fails retryAttempts times.
+func (h *Poa) retry(block *types.Block, stage string, fn func() error) error {
+	backoff := retryBackoff
+	var err error
+	for attempt := 1; attempt <= retryAttempts; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+		logrus.Warnf("%s of block(%d) failed at attempt %d: %v", stage, block.Height, attempt, err)
+		if attempt < retryAttempts {
+			h.clock.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	return BlockFailed(block, stage, RetryableFailure, err)
+}
+
+// skipSlot gives up the block of the local leader, and moves to the next round of the height.
+func (h *Poa) skipSlot(block *types.Block, stage string, err error) {
+	logrus.Error(BlockFailed(block, stage, SkipSlotFailure, err))
+	h.enterRound(block.Height, BlockRound(block)+1)
+}
+
+// fail records the block, stops the block lifecycle and shuts down the node.
+// The p2p messages are ignored since then, so nothing touches the db but the queries.
+func (h *Poa) fail(block *types.Block, stage string, err error) {
+	failed := BlockFailed(block, stage, FatalFailure, err)
+	logrus.Error(failed)
+	h.failed.Store(true)
+
+	failure := &BlockFailure{
+		Height:    block.Height,
+		Round:     BlockRound(block),
+		BlockHash: block.Hash,
+		Stage:     stage,
+		Error:     err.Error(),
+		Time:      h.nowTs(),
+	}
+	byt, encErr := block.Encode()
+	if encErr != nil {
+		logrus.Error("encode failed block: ", encErr)
+	} else {
+		failure.Block = byt
+	}
+	storeErr := h.storeBlockFailure(failure)
+	if storeErr != nil {
+		logrus.Error("store block failure: ", storeErr)
+	}
+	go h.shutdown(failed)
+}
+
+// parkIfFailed keeps the block lifecycle from going on after a fatal failure,
+// until poa is closed or StopOnFatal stops the kernel.
+// It returns true if the node has failed, then no block is made.
+func (h *Poa) parkIfFailed() bool {
+	if !h.failed.Load() {
+		return false
+	}
+	<-h.stopCh
+	return true
+}
+
+func blockFailureKey(height common.BlockNum) []byte {
+	key := make([]byte, len(blockFailurePrefix)+8)
+	copy(key, blockFailurePrefix)
+	binary.BigEndian.PutUint64(key[len(blockFailurePrefix):], uint64(height))
+	return key
+}
+
+func (h *Poa) storeBlockFailure(failure *BlockFailure) error {
+	byt, err := json.Marshal(failure)
+	if err != nil {
+		return err
+	}
+	return h.db.Set(blockFailureKey(failure.Height), byt, pebble.Sync)
+}
+
+// GetBlockFailures returns the recorded fatal failures, ascending by height.
+func (h *Poa) GetBlockFailures() ([]*BlockFailure, error) {
+	upper := make([]byte, len(blockFailurePrefix))
+	copy(upper, blockFailurePrefix)
+	upper[len(upper)-1]++
+	iter, err := h.db.NewIter(&pebble.IterOptions{LowerBound: blockFailurePrefix, UpperBound: upper})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	failures := make([]*BlockFailure, 0)
+	for iter.First(); iter.Valid(); iter.Next() {
+		failure := new(BlockFailure)
+		err = json.Unmarshal(iter.Value(), failure)
+		if err != nil {
+			return nil, err
+		}
+		failures = append(failures, failure)
+	}
+	return failures, iter.Error()
+}
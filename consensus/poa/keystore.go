@@ -0,0 +1,227 @@
+package poa
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"golang.org/x/crypto/scrypt"
+	"os"
+	"strings"
+)
+
+const (
+	KeystoreVersion = 1
+	// the env var holding the passphrase of keystore, if PoaConfig.PassphraseEnv is empty.
+	DefaultPassphraseEnv = "POA_KEYSTORE_PASSPHRASE"
+
+	scryptN      = 1 << 16
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+var ErrKeystorePassphrase = errors.New("wrong passphrase of keystore")
+
+// Keystore keeps the secret of a validator key encrypted by a passphrase.
+// The secret is encrypted by AES-GCM with the key derived from the passphrase by scrypt.
+type Keystore struct {
+	Version int    `json:"version"`
+	KeyType string `json:"key_type"`
+	// the pubkey with type, for checking the key without the passphrase
+	Pubkey string         `json:"pubkey"`
+	Crypto KeystoreCrypto `json:"crypto"`
+}
+
+type KeystoreCrypto struct {
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       string `json:"salt"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// NewKeystore encrypts the secret which generates the key of keyType.
+func NewKeystore(keyType string, secret, passphrase []byte) (*Keystore, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("empty secret")
+	}
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	pub, _, err := keypair.GenKeyPairWithSecret(keyType, secret)
+	if err != nil {
+		return nil, err
+	}
+	if pub == nil {
+		return nil, errors.Errorf("key type(%s) has no key to store", keyType)
+	}
+
+	salt := make([]byte, 32)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := keystoreCipher(passphrase, salt, scryptN, scryptR, scryptP)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return &Keystore{
+		Version: KeystoreVersion,
+		KeyType: keyType,
+		Pubkey:  pub.StringWithType(),
+		Crypto: KeystoreCrypto{
+			N:          scryptN,
+			R:          scryptR,
+			P:          scryptP,
+			Salt:       common.ToHex(salt),
+			Nonce:      common.ToHex(nonce),
+			Ciphertext: common.ToHex(aead.Seal(nil, nonce, secret, nil)),
+		},
+	}, nil
+}
+
+// GenKeystore makes a keystore with a random secret.
+func GenKeystore(keyType string, passphrase []byte) (*Keystore, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeystore(keyType, []byte(common.ToHex(secret)), passphrase)
+}
+
+func keystoreCipher(passphrase, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, n, r, p, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Decrypt returns the secret in the keystore.
+func (ks *Keystore) Decrypt(passphrase []byte) ([]byte, error) {
+	if ks.Version != KeystoreVersion {
+		return nil, errors.Errorf("unsupported keystore version(%d)", ks.Version)
+	}
+	c := ks.Crypto
+	aead, err := keystoreCipher(passphrase, common.FromHex(c.Salt), c.N, c.R, c.P)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := aead.Open(nil, common.FromHex(c.Nonce), common.FromHex(c.Ciphertext), nil)
+	if err != nil {
+		return nil, ErrKeystorePassphrase
+	}
+	return secret, nil
+}
+
+// KeyPair decrypts the keystore and generates the key pair from the secret.
+func (ks *Keystore) KeyPair(passphrase []byte) (keypair.PubKey, keypair.PrivKey, error) {
+	secret, err := ks.Decrypt(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ks.keyPair(secret)
+}
+
+func (ks *Keystore) keyPair(secret []byte) (keypair.PubKey, keypair.PrivKey, error) {
+	pub, priv, err := keypair.GenKeyPairWithSecret(ks.KeyType, secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pub.StringWithType() != ks.Pubkey {
+		return nil, nil, errors.Errorf("keystore is broken, the key(%s) mismatches pubkey(%s)",
+			pub.StringWithType(), ks.Pubkey)
+	}
+	return pub, priv, nil
+}
+
+// Save writes the keystore into a new file readable by the owner only.
+func (ks *Keystore) Save(path string) error {
+	byt, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(byt)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func LoadKeystore(path string) (*Keystore, error) {
+	byt, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ks := new(Keystore)
+	err = json.Unmarshal(byt, ks)
+	return ks, err
+}
+
+// ReadPassphrase reads the passphrase from the file if it is set, otherwise from the env var.
+func ReadPassphrase(env, file string) ([]byte, error) {
+	if file != "" {
+		byt, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.TrimRight(string(byt), "\r\n")), nil
+	}
+	if env == "" {
+		env = DefaultPassphraseEnv
+	}
+	passphrase, ok := os.LookupEnv(env)
+	if !ok || passphrase == "" {
+		return nil, errors.Errorf("no passphrase in env(%s)", env)
+	}
+	return []byte(passphrase), nil
+}
+
+// IsDefaultSecret tells if the secret is one of DefaultSecrets, which everyone knows.
+func IsDefaultSecret(secret []byte) bool {
+	for _, defaultSecret := range DefaultSecrets {
+		if string(secret) == defaultSecret {
+			return true
+		}
+	}
+	return false
+}
+
+// checkProduction refuses the DefaultSecrets for the local key and the validators in a production config.
+func checkProduction(keyType string, secret []byte, validators []*ValidatorConf) error {
+	if IsDefaultSecret(secret) {
+		return errors.New("production config cannot use the default secret")
+	}
+	for _, defaultSecret := range DefaultSecrets {
+		pub, _, err := keypair.GenKeyPairWithSecret(keyType, []byte(defaultSecret))
+		if err != nil {
+			return err
+		}
+		for _, validator := range validators {
+			if strings.EqualFold(validator.Pubkey, pub.StringWithType()) {
+				return errors.Errorf("validator(%s) of production config uses the default secret", validator.Pubkey)
+			}
+		}
+	}
+	return nil
+}
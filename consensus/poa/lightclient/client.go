@@ -0,0 +1,158 @@
+// Package lightclient verifies the headers of a Poa chain without executing its txns.
+// It follows the validator set by the signed checkpoints on the epoch ends.
+package lightclient
+
+import (
+	"github.com/pkg/errors"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/common/yerror"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+)
+
+type Config struct {
+	// how the leader of each height is elected on the chain, default is poa.RoundRobinElection.
+	LeaderElection string
+	// the epoch length of the chain. Without epochs, the validator set never changes for the client,
+	// nor do the jailed validators.
+	EpochLength uint64
+}
+
+// Client keeps the last verified header and the validators trusted after it.
+type Client struct {
+	cfg        Config
+	head       *types.Header
+	validators *poa.ValidatorSet
+	// the validators leading the rounds, the jailed ones are not
+	leaders *poa.ValidatorSet
+	// the epoch the validators work in
+	epoch uint64
+}
+
+// New trusts the validators from the child of head on, such as the genesis header with the genesis validators.
+func New(cfg Config, head *types.Header, validators *poa.ValidatorSet) *Client {
+	c := &Client{cfg: cfg, head: head, validators: validators, leaders: validators}
+	c.epoch = c.epochOf(head.Height + 1)
+	return c
+}
+
+// NewFromCheckpoint trusts the validators of the checkpoint, head is the block of the checkpoint.
+func NewFromCheckpoint(cfg Config, head *types.Header, cp *poa.Checkpoint) (*Client, error) {
+	if hash := poa.HeaderHash(head); hash != head.Hash {
+		return nil, poa.BlockHashMismatch(head.Hash, hash)
+	}
+	if cp.Height != head.Height || cp.BlockHash != head.Hash {
+		return nil, CheckpointMismatch(cp.Epoch, cp.BlockHash, head.Hash)
+	}
+	validators, err := cp.ValidatorSet()
+	if err != nil {
+		return nil, err
+	}
+	c := New(cfg, head, validators)
+	c.leaders, err = cp.LeaderSet()
+	if err != nil {
+		return nil, err
+	}
+	if c.epoch != cp.Epoch {
+		return nil, errors.Errorf("checkpoint of epoch(%d) is not on an epoch end", cp.Epoch)
+	}
+	return c, nil
+}
+
+func (c *Client) Head() *types.Header {
+	return c.head
+}
+
+func (c *Client) Validators() *poa.ValidatorSet {
+	return c.validators
+}
+
+func (c *Client) Epoch() uint64 {
+	return c.epoch
+}
+
+func (c *Client) epochOf(height common.BlockNum) uint64 {
+	if c.cfg.EpochLength == 0 || height == 0 {
+		return 0
+	}
+	return (uint64(height) - 1) / c.cfg.EpochLength
+}
+
+// Verify checks the child header of the head is proposed by its leader and finalized by cert,
+// then it becomes the head.
+func (c *Client) Verify(header *types.Header, cert *poa.FinalityCert) error {
+	if header.Height != c.head.Height+1 {
+		return poa.HeightNotContinuous(header.Hash, header.Height, c.head.Height)
+	}
+	if header.PrevHash != c.head.Hash {
+		return poa.ParentNotFound(header.Hash, header.PrevHash)
+	}
+	if epoch := c.epochOf(header.Height); epoch != c.epoch {
+		return CheckpointRequired(header.Height, epoch)
+	}
+	if hash := poa.HeaderHash(header); hash != header.Hash {
+		return poa.BlockHashMismatch(header.Hash, hash)
+	}
+
+	minerPubkey, err := keypair.PubKeyFromBytes(header.MinerPubkey)
+	if err != nil {
+		return err
+	}
+	if minerPubkey == nil {
+		return errors.Errorf("header(%s) has no miner pubkey", header.Hash.String())
+	}
+	miner := minerPubkey.Address()
+	if !c.validators.Contains(miner) {
+		return poa.MinerNotValidator(miner, header.Height)
+	}
+	round := poa.BlockRound(&types.Block{Header: header})
+	leader := poa.ElectLeader(c.leaders, c.cfg.LeaderElection, header.Height, c.head, round)
+	if leader != miner {
+		return poa.MinerNotLeader(miner, leader, header.Height, round)
+	}
+	if !minerPubkey.VerifySignature(header.Hash.Bytes(), header.MinerSignature) {
+		return yerror.BlockSignatureIllegal(header.Hash)
+	}
+
+	if cert == nil {
+		return errors.Errorf("header(%s) has no finality certificate", header.Hash.String())
+	}
+	if cert.Height != header.Height || cert.BlockHash != header.Hash ||
+		cert.StateRoot != header.StateRoot || cert.ReceiptRoot != header.ReceiptRoot {
+		return CertMismatch(header.Hash, cert.BlockHash)
+	}
+	err = cert.Verify(c.validators)
+	if err != nil {
+		return err
+	}
+	c.head = header
+	return nil
+}
+
+// ApplyCheckpoint moves to the validators of the next epoch by the checkpoint on the head,
+// which must end the current epoch.
+func (c *Client) ApplyCheckpoint(cp *poa.SignedCheckpoint) error {
+	if cp.Height != c.head.Height || cp.BlockHash != c.head.Hash {
+		return CheckpointMismatch(cp.Epoch, cp.BlockHash, c.head.Hash)
+	}
+	if cp.Epoch != c.epoch+1 || c.epochOf(cp.Height+1) != cp.Epoch {
+		return errors.Errorf("checkpoint of epoch(%d) does not follow epoch(%d)", cp.Epoch, c.epoch)
+	}
+	err := cp.Verify(c.validators)
+	if err != nil {
+		return err
+	}
+	validators, err := cp.ValidatorSet()
+	if err != nil {
+		return err
+	}
+	leaders, err := cp.LeaderSet()
+	if err != nil {
+		return err
+	}
+	c.validators = validators
+	c.leaders = leaders
+	c.epoch = cp.Epoch
+	return nil
+}
@@ -0,0 +1,59 @@
+package lightclient
+
+import (
+	"fmt"
+	"github.com/yu-org/yu/common"
+)
+
+type ErrCheckpointRequired struct {
+	Height common.BlockNum
+	Epoch  uint64
+}
+
+func CheckpointRequired(height common.BlockNum, epoch uint64) ErrCheckpointRequired {
+	return ErrCheckpointRequired{Height: height, Epoch: epoch}
+}
+
+func (e ErrCheckpointRequired) Error() string {
+	return fmt.Sprintf("header of height(%d) needs the checkpoint of epoch(%d)", e.Height, e.Epoch)
+}
+
+type ErrCheckpointMismatch struct {
+	Epoch     uint64
+	BlockHash common.Hash
+	Head      common.Hash
+}
+
+func CheckpointMismatch(epoch uint64, blockHash, head common.Hash) ErrCheckpointMismatch {
+	return ErrCheckpointMismatch{Epoch: epoch, BlockHash: blockHash, Head: head}
+}
+
+func (e ErrCheckpointMismatch) Error() string {
+	return fmt.Sprintf("checkpoint of epoch(%d) on block(%s) mismatches the head(%s)", e.Epoch, e.BlockHash.String(), e.Head.String())
+}
+
+type ErrCertMismatch struct {
+	BlockHash common.Hash
+	CertHash  common.Hash
+}
+
+func CertMismatch(blockHash, certHash common.Hash) ErrCertMismatch {
+	return ErrCertMismatch{BlockHash: blockHash, CertHash: certHash}
+}
+
+func (e ErrCertMismatch) Error() string {
+	return fmt.Sprintf("finality certificate of block(%s) mismatches the header(%s)", e.CertHash.String(), e.BlockHash.String())
+}
+
+type ErrTxnNotCommitted struct {
+	TxnHash common.Hash
+	TxnRoot common.Hash
+}
+
+func TxnNotCommitted(txnHash, txnRoot common.Hash) ErrTxnNotCommitted {
+	return ErrTxnNotCommitted{TxnHash: txnHash, TxnRoot: txnRoot}
+}
+
+func (e ErrTxnNotCommitted) Error() string {
+	return fmt.Sprintf("txn(%s) is not committed by the txn root(%s)", e.TxnHash.String(), e.TxnRoot.String())
+}
@@ -0,0 +1,39 @@
+package lightclient
+
+import (
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+)
+
+// TxnProof proves a txn is committed by the TxnRoot of its block.
+type TxnProof struct {
+	TxnHash common.Hash `json:"txn_hash"`
+	// the position of the txn in the block
+	Index uint64 `json:"index"`
+	// the number of txns in the block
+	Count uint64 `json:"count"`
+	// the sibling hashes from the leaf up to the root
+	Siblings []common.Hash `json:"siblings"`
+}
+
+// ProveTxn makes the proof of the txn at index in a block, txnHashes are the hashes of all txns in the block.
+func ProveTxn(txnHashes []common.Hash, index int) (*TxnProof, error) {
+	siblings, err := poa.MerkleProof(txnHashes, index)
+	if err != nil {
+		return nil, err
+	}
+	return &TxnProof{
+		TxnHash:  txnHashes[index],
+		Index:    uint64(index),
+		Count:    uint64(len(txnHashes)),
+		Siblings: siblings,
+	}, nil
+}
+
+// VerifyTxn checks the proof against the txn root of a verified header.
+func VerifyTxn(txnRoot common.Hash, proof *TxnProof) error {
+	if !poa.VerifyMerkleProof(txnRoot, proof.TxnHash, proof.Index, proof.Count, proof.Siblings) {
+		return TxnNotCommitted(proof.TxnHash, txnRoot)
+	}
+	return nil
+}
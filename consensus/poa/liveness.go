@@ -0,0 +1,377 @@
+package poa
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/context"
+	"github.com/yu-org/yu/core/types"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+var (
+	livenessPrefix = []byte("liveness_")
+	jailTermsKey   = []byte("jail_terms")
+)
+
+// Liveness is the slots a validator led in the recent LivenessWindow blocks, it is kept in the state
+// so that all validators jail the same ones on the same height.
+type Liveness struct {
+	// the heights the validator led a round on
+	Slots []common.BlockNum `json:"slots"`
+	// the heights the validator led a round on but did not propose in it
+	Missed []common.BlockNum `json:"missed"`
+}
+
+// prune drops the slots before height.
+func (l *Liveness) prune(height common.BlockNum) {
+	l.Slots = heightsSince(l.Slots, height)
+	l.Missed = heightsSince(l.Missed, height)
+}
+
+func heightsSince(heights []common.BlockNum, height common.BlockNum) []common.BlockNum {
+	i := sort.Search(len(heights), func(i int) bool {
+		return heights[i] >= height
+	})
+	return heights[i:]
+}
+
+// JailTerm keeps a validator out of the leader schedule from the height From until the height Until.
+// Both are epoch starts, so the leaders of an epoch are fixed by its checkpoint.
+type JailTerm struct {
+	Validator common.Address  `json:"validator"`
+	From      common.BlockNum `json:"from"`
+	// 0 until the validator is unjailed
+	Until common.BlockNum `json:"until,omitempty"`
+}
+
+func (t *JailTerm) covers(height common.BlockNum) bool {
+	return t.From <= height && (t.Until == 0 || height < t.Until)
+}
+
+// openJailTerm returns the term addr is not released from yet, nil if there is none.
+func openJailTerm(terms []*JailTerm, addr common.Address) *JailTerm {
+	for _, term := range terms {
+		if term.Validator == addr && term.Until == 0 {
+			return term
+		}
+	}
+	return nil
+}
+
+// jailSchedule caches the jail terms in the state for the leader election.
+// The released terms are kept, so the jailed validators of any height can be told.
+type jailSchedule struct {
+	sync.RWMutex
+	terms []*JailTerm
+}
+
+func newJailSchedule() *jailSchedule {
+	return &jailSchedule{}
+}
+
+// at returns the validators jailed on height.
+func (js *jailSchedule) at(height common.BlockNum) []common.Address {
+	js.RLock()
+	defer js.RUnlock()
+	var jailed []common.Address
+	for _, term := range js.terms {
+		if term.covers(height) {
+			jailed = append(jailed, term.Validator)
+		}
+	}
+	return jailed
+}
+
+// last returns the latest term of addr, nil if it is never jailed.
+func (js *jailSchedule) last(addr common.Address) *JailTerm {
+	js.RLock()
+	defer js.RUnlock()
+	for i := len(js.terms) - 1; i >= 0; i-- {
+		if js.terms[i].Validator == addr {
+			term := *js.terms[i]
+			return &term
+		}
+	}
+	return nil
+}
+
+func (js *jailSchedule) set(terms []*JailTerm) {
+	js.Lock()
+	defer js.Unlock()
+	js.terms = terms
+}
+
+// LeadersAt returns the validators who lead the rounds of height, the jailed ones are skipped.
+func (h *Poa) LeadersAt(height common.BlockNum) *ValidatorSet {
+	return h.ValidatorSetAt(height).Exclude(h.jails.at(height))
+}
+
+// livenessStart returns the first height of the liveness window ending at height.
+func (h *Poa) livenessStart(height common.BlockNum) common.BlockNum {
+	if uint64(height) < h.cfg.LivenessWindow {
+		return 1
+	}
+	return height - common.BlockNum(h.cfg.LivenessWindow) + 1
+}
+
+// commitLiveness records the slots of the leaders of the rounds up to the one of block,
+// the leaders of the rounds before it missed their slots. Every leader is counted once on a height,
+// however many rounds it missed.
+func (h *Poa) commitLiveness(block *types.Block) error {
+	if h.cfg.LivenessWindow == 0 {
+		return nil
+	}
+	start := h.livenessStart(block.Height)
+	round := BlockRound(block)
+	n := uint64(h.LeadersAt(block.Height).Len())
+
+	records := make(map[common.Address]*Liveness)
+	// in the order of rounds, the state is written the same on all validators
+	leaders := make([]common.Address, 0)
+	// the leaders take turns in the rounds, so the first n rounds have all of them.
+	for r := uint64(0); r <= round && r < n; r++ {
+		leader, err := h.LeaderOf(block.Height, block.PrevHash, r)
+		if err != nil {
+			return err
+		}
+		record, ok := records[leader]
+		if !ok {
+			record, err = h.getLiveness(leader)
+			if err != nil {
+				return err
+			}
+			record.prune(start)
+			record.Slots = append(record.Slots, block.Height)
+			records[leader] = record
+			leaders = append(leaders, leader)
+		}
+		if r < round {
+			record.Missed = append(record.Missed, block.Height)
+			MissedSlots.WithLabelValues(leader.String()).Inc()
+			logrus.Warnf("validator(%s) missed its slot on height(%d) round(%d), %d missed in the last %d blocks",
+				leader.String(), block.Height, r, len(record.Missed), h.cfg.LivenessWindow)
+		}
+	}
+
+	for _, leader := range leaders {
+		record := records[leader]
+		err := h.setLiveness(leader, record)
+		if err != nil {
+			return err
+		}
+		if h.cfg.JailThreshold > 0 && uint64(len(record.Missed)) >= h.cfg.JailThreshold {
+			err = h.jail(leader, block.Height)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jail takes the validator out of the leader schedule from the next epoch on,
+// unless it is jailed already or too few validators would be left to lead.
+func (h *Poa) jail(addr common.Address, height common.BlockNum) error {
+	terms, err := h.loadJailTerms()
+	if err != nil {
+		return err
+	}
+	if openJailTerm(terms, addr) != nil {
+		return nil
+	}
+	from := h.nextEpochStart(height)
+	set := h.ValidatorSetAt(from)
+	if !set.Contains(addr) {
+		return nil
+	}
+	jailed := 0
+	for _, term := range terms {
+		if term.covers(from) && set.Contains(term.Validator) {
+			jailed++
+		}
+	}
+	// more than 1/3 validators stay in the leader schedule, so that an online leader comes soon in the rounds.
+	if set.Len()-jailed-1 < weakQuorum(set.Len()) {
+		logrus.Warnf("validator(%s) is not jailed on height(%d), %d of %d validators are jailed already",
+			addr.String(), height, jailed, set.Len())
+		return nil
+	}
+
+	terms = append(terms, &JailTerm{Validator: addr, From: from})
+	err = h.storeJailTerms(terms)
+	if err != nil {
+		return err
+	}
+	h.jailsChanged = true
+	logrus.Warnf("validator(%s) is jailed from height(%d) for missing %d slots in the last %d blocks",
+		addr.String(), from, h.cfg.JailThreshold, h.cfg.LivenessWindow)
+	return nil
+}
+
+// Unjail puts the jailed caller back to the leader schedule from the next epoch on, and forgets its missed slots.
+// params: {}
+func (h *Poa) Unjail(ctx *context.WriteContext) error {
+	ctx.SetLei(1)
+	caller, err := callerAddress(ctx.Txn)
+	if err != nil {
+		return err
+	}
+	if !h.ValidatorSetAt(ctx.Block.Height).Contains(caller) {
+		return errors.Errorf("caller(%s) is not validator", caller.String())
+	}
+	terms, err := h.loadJailTerms()
+	if err != nil {
+		return err
+	}
+	term := openJailTerm(terms, caller)
+	if term == nil {
+		return ValidatorNotJailed(caller, ctx.Block.Height)
+	}
+
+	until := h.nextEpochStart(ctx.Block.Height)
+	if term.From >= until {
+		// the term has not begun yet
+		for i := range terms {
+			if terms[i] == term {
+				terms = append(terms[:i], terms[i+1:]...)
+				break
+			}
+		}
+		term.Until = term.From
+	} else {
+		term.Until = until
+	}
+	err = h.storeJailTerms(terms)
+	if err != nil {
+		return err
+	}
+	h.Delete(livenessKey(caller))
+	h.jailsChanged = true
+
+	logrus.Infof("validator(%s) is unjailed from height(%d)", caller.String(), term.Until)
+	return ctx.EmitJsonEvent(term)
+}
+
+func livenessKey(addr common.Address) []byte {
+	return append(append([]byte{}, livenessPrefix...), addr.Bytes()...)
+}
+
+func (h *Poa) getLiveness(addr common.Address) (*Liveness, error) {
+	return decodeLiveness(h.Get(livenessKey(addr)))
+}
+
+func decodeLiveness(byt []byte, err error) (*Liveness, error) {
+	if err != nil {
+		return nil, err
+	}
+	record := new(Liveness)
+	if byt == nil {
+		return record, nil
+	}
+	err = json.Unmarshal(byt, record)
+	return record, err
+}
+
+func (h *Poa) setLiveness(addr common.Address, record *Liveness) error {
+	byt, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	h.Set(livenessKey(addr), byt)
+	return nil
+}
+
+func (h *Poa) loadJailTerms() ([]*JailTerm, error) {
+	byt, err := h.Get(jailTermsKey)
+	if err != nil {
+		return nil, err
+	}
+	terms := make([]*JailTerm, 0)
+	if byt == nil {
+		return terms, nil
+	}
+	err = json.Unmarshal(byt, &terms)
+	return terms, err
+}
+
+func (h *Poa) storeJailTerms(terms []*JailTerm) error {
+	byt, err := json.Marshal(terms)
+	if err != nil {
+		return err
+	}
+	h.Set(jailTermsKey, byt)
+	return nil
+}
+
+func (h *Poa) reloadJails() error {
+	terms, err := h.loadJailTerms()
+	if err != nil {
+		return err
+	}
+	h.jails.set(terms)
+	return nil
+}
+
+// ValidatorUptime is the liveness of a validator in the last LivenessWindow blocks.
+type ValidatorUptime struct {
+	Validator common.Address `json:"validator"`
+	Slots     int            `json:"slots"`
+	Missed    int            `json:"missed"`
+	// the share of the slots the validator proposed in, 1 if it has no slots.
+	Uptime float64 `json:"uptime"`
+	// the validator is out of the leader schedule of the next block
+	Jailed bool `json:"jailed"`
+	// the latest jail term of the validator, nil if it is never jailed
+	Jail *JailTerm `json:"jail,omitempty"`
+}
+
+// GetUptimes returns the liveness of the validators of the next block, by the committed state.
+func (h *Poa) GetUptimes() ([]*ValidatorUptime, error) {
+	end, err := h.Chain.GetEndCompactBlock()
+	if err != nil {
+		return nil, err
+	}
+	next := end.Height + 1
+	start := h.livenessStart(end.Height)
+	jailed := make(map[common.Address]bool)
+	for _, addr := range h.jails.at(next) {
+		jailed[addr] = true
+	}
+
+	set := h.ValidatorSetAt(next)
+	uptimes := make([]*ValidatorUptime, 0, set.Len())
+	for _, addr := range set.Addrs {
+		// the state of the block in execution is not read
+		record, err := decodeLiveness(h.GetFinalized(livenessKey(addr)))
+		if err != nil {
+			return nil, err
+		}
+		record.prune(start)
+		uptime := &ValidatorUptime{
+			Validator: addr,
+			Slots:     len(record.Slots),
+			Missed:    len(record.Missed),
+			Uptime:    1,
+			Jailed:    jailed[addr],
+			Jail:      h.jails.last(addr),
+		}
+		if uptime.Slots > 0 {
+			uptime.Uptime = float64(uptime.Slots-uptime.Missed) / float64(uptime.Slots)
+		}
+		uptimes = append(uptimes, uptime)
+	}
+	return uptimes, nil
+}
+
+func (h *Poa) QueryUptimes(ctx *context.ReadContext) {
+	uptimes, err := h.GetUptimes()
+	if err != nil {
+		ctx.Err(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JsonOk(uptimes)
+}
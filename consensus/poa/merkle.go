@@ -0,0 +1,110 @@
+package poa
+
+import (
+	"crypto/sha256"
+	"github.com/pkg/errors"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/types"
+)
+
+// the prefixes of the merkle nodes, so a leaf can never pass for an inner node
+const (
+	merkleLeafPrefix   = 0x00
+	merkleParentPrefix = 0x01
+)
+
+// TxnRoot is the merkle root of the txns of a block, it commits every txn.
+// types.MakeTxnRoot is not used, its tree drops the last node of an odd level above the leaves.
+func TxnRoot(txns []*types.SignedTxn) common.Hash {
+	hashes := make([]common.Hash, 0, len(txns))
+	for _, txn := range txns {
+		hashes = append(hashes, txn.TxnHash)
+	}
+	return MerkleRoot(hashes)
+}
+
+// MerkleRoot returns the root of the merkle tree over hashes, the null hash if there are none.
+// The last node of an odd level moves up to the next level as it is.
+func MerkleRoot(hashes []common.Hash) common.Hash {
+	if len(hashes) == 0 {
+		return common.NullHash
+	}
+	level := merkleLeaves(hashes)
+	for len(level) > 1 {
+		level = nextMerkleLevel(level)
+	}
+	return level[0]
+}
+
+// MerkleProof returns the sibling hashes from the leaf of hashes[index] up to the root.
+func MerkleProof(hashes []common.Hash, index int) ([]common.Hash, error) {
+	if index < 0 || index >= len(hashes) {
+		return nil, errors.Errorf("index(%d) is out of %d hashes", index, len(hashes))
+	}
+	level := merkleLeaves(hashes)
+	siblings := make([]common.Hash, 0)
+	pos := index
+	for len(level) > 1 {
+		// the last node of an odd level has no sibling
+		if sibling := pos ^ 1; sibling < len(level) {
+			siblings = append(siblings, level[sibling])
+		}
+		level = nextMerkleLevel(level)
+		pos /= 2
+	}
+	return siblings, nil
+}
+
+// VerifyMerkleProof checks that hash is the one at index of the count hashes under root.
+func VerifyMerkleProof(root, hash common.Hash, index, count uint64, siblings []common.Hash) bool {
+	if index >= count {
+		return false
+	}
+	node := merkleLeaf(hash)
+	used := 0
+	for pos, n := index, count; n > 1; pos, n = pos/2, (n+1)/2 {
+		if pos%2 == 0 && pos+1 == n {
+			continue
+		}
+		if used >= len(siblings) {
+			return false
+		}
+		if pos%2 == 0 {
+			node = merkleParent(node, siblings[used])
+		} else {
+			node = merkleParent(siblings[used], node)
+		}
+		used++
+	}
+	return used == len(siblings) && node == root
+}
+
+func merkleLeaves(hashes []common.Hash) []common.Hash {
+	leaves := make([]common.Hash, 0, len(hashes))
+	for _, hash := range hashes {
+		leaves = append(leaves, merkleLeaf(hash))
+	}
+	return leaves
+}
+
+func nextMerkleLevel(level []common.Hash) []common.Hash {
+	next := make([]common.Hash, 0, (len(level)+1)/2)
+	for i := 0; i+1 < len(level); i += 2 {
+		next = append(next, merkleParent(level[i], level[i+1]))
+	}
+	if len(level)%2 != 0 {
+		next = append(next, level[len(level)-1])
+	}
+	return next
+}
+
+func merkleLeaf(hash common.Hash) common.Hash {
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, hash.Bytes()...))
+}
+
+func merkleParent(left, right common.Hash) common.Hash {
+	byt := make([]byte, 0, 1+2*common.HashLen)
+	byt = append(byt, merkleParentPrefix)
+	byt = append(byt, left.Bytes()...)
+	return sha256.Sum256(append(byt, right.Bytes()...))
+}
@@ -0,0 +1,35 @@
+package poa
+
+import "github.com/prometheus/client_golang/prometheus"
+
+const (
+	// the block is proposed by the local node
+	proposedBlock = "proposed"
+	// the block is received from the leader
+	receivedBlock = "received"
+)
+
+var BlockLateness = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "poa",
+		Subsystem: "block",
+		Name:      "lateness_seconds",
+		Help:      "How late the block is proposed or received after its slot begins",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	},
+	[]string{"source"},
+)
+
+var MissedSlots = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "poa",
+		Subsystem: "validator",
+		Name:      "missed_slots_total",
+		Help:      "How many committed slots the validator led a round in but did not propose",
+	},
+	[]string{"validator"},
+)
+
+func init() {
+	prometheus.MustRegister(BlockLateness, MissedSlots)
+}
@@ -0,0 +1,112 @@
+package poa
+
+import (
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/types"
+	"sort"
+)
+
+const (
+	// the txns are packed in the order they enter the txpool.
+	FifoOrder = "fifo"
+	// the txns with higher tips are packed first, the equal ones keep the fifo order.
+	TipOrder = "tip"
+	// the txns of each sender are packed by their nonces, in the places the sender's txns take in the fifo order.
+	NonceOrder = "nonce"
+)
+
+// orderTxns sorts the txns of the txpool by the TxnOrder before packing.
+func (h *Poa) orderTxns(txns []*types.SignedTxn) []*types.SignedTxn {
+	switch h.cfg.TxnOrder {
+	case TipOrder:
+		sort.SliceStable(txns, func(i, j int) bool {
+			return txns[i].GetTips() > txns[j].GetTips()
+		})
+	case NonceOrder:
+		sortSenderNonces(txns)
+	}
+	return txns
+}
+
+type noncedTxn struct {
+	nonce uint64
+	txn   *types.SignedTxn
+}
+
+// sortSenderNonces sorts the txns of every sender by nonce, the txns without a nonce stay where they are.
+func sortSenderNonces(txns []*types.SignedTxn) {
+	places := make(map[common.Address][]int)
+	nonced := make(map[common.Address][]noncedTxn)
+	for i, txn := range txns {
+		sender, nonce, err := senderNonce(txn)
+		if err != nil {
+			continue
+		}
+		places[sender] = append(places[sender], i)
+		nonced[sender] = append(nonced[sender], noncedTxn{nonce: nonce, txn: txn})
+	}
+	for sender, senderTxns := range nonced {
+		sort.SliceStable(senderTxns, func(i, j int) bool {
+			return senderTxns[i].nonce < senderTxns[j].nonce
+		})
+		for j, i := range places[sender] {
+			txns[i] = senderTxns[j].txn
+		}
+	}
+}
+
+type senderNonceKey struct {
+	sender common.Address
+	nonce  uint64
+}
+
+// packFilter returns the filter of the txns packed into a block, which fits them into limit.
+// In the nonce order a block takes one txn of each sender and nonce, since verifyTxnOrder refuses the repeated ones;
+// the others stay in the txpool for the later blocks.
+func (h *Poa) packFilter(limit *packLimit) func(*types.SignedTxn) bool {
+	if h.cfg.TxnOrder != NonceOrder {
+		return limit.add
+	}
+	packed := make(map[senderNonceKey]struct{})
+	return func(txn *types.SignedTxn) bool {
+		sender, nonce, err := senderNonce(txn)
+		if err != nil {
+			return limit.add(txn)
+		}
+		key := senderNonceKey{sender: sender, nonce: nonce}
+		if _, ok := packed[key]; ok {
+			return false
+		}
+		if !limit.add(txn) {
+			return false
+		}
+		packed[key] = struct{}{}
+		return true
+	}
+}
+
+// verifyTxnOrder checks the leader packed the txns of block by the TxnOrder.
+// The fifo order cannot be verified, since the txns enter the txpool of every validator in its own order.
+func (h *Poa) verifyTxnOrder(block *types.Block) error {
+	switch h.cfg.TxnOrder {
+	case TipOrder:
+		for i := 1; i < len(block.Txns); i++ {
+			if block.Txns[i].GetTips() > block.Txns[i-1].GetTips() {
+				return TxnOrderViolated(block.Hash, TipOrder, block.Txns[i].TxnHash)
+			}
+		}
+	case NonceOrder:
+		last := make(map[common.Address]uint64)
+		for _, txn := range block.Txns {
+			sender, nonce, err := senderNonce(txn)
+			if err != nil {
+				continue
+			}
+			if prev, ok := last[sender]; ok && nonce <= prev {
+				return TxnOrderViolated(block.Hash, NonceOrder, txn.TxnHash)
+			}
+			last[sender] = nonce
+		}
+	}
+	return nil
+}
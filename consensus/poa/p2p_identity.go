@@ -0,0 +1,76 @@
+package poa
+
+import (
+	"crypto/ed25519"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pkg/errors"
+	"github.com/yu-org/yu/config"
+	"github.com/yu-org/yu/core/keypair"
+)
+
+// PeerIDFromPubkey derives the libp2p peer ID from the ed25519 pubkey of a validator,
+// it is the ID of the node whose libp2p key is set by SetP2pIdentity.
+// Other key types cannot be used, since yu only makes ed25519 libp2p keys from a given seed.
+func PeerIDFromPubkey(pubkey keypair.PubKey) (peer.ID, error) {
+	if pubkey == nil {
+		return "", errors.New("no pubkey to derive peer id")
+	}
+	if pubkey.Type() != keypair.Ed25519 {
+		return "", errors.Errorf("%s pubkey(%s) cannot derive peer id, only ed25519 can", pubkey.Type(), pubkey.String())
+	}
+	p2pPubkey, err := crypto.UnmarshalEd25519PublicKey(pubkey.Bytes())
+	if err != nil {
+		return "", err
+	}
+	return peer.IDFromPublicKey(p2pPubkey)
+}
+
+// SetP2pIdentity makes the libp2p key of the local node from its validator key,
+// so other validators find it by the peer ID derived from its pubkey.
+// The validator key must be local, a node signing by RemoteSigner needs its own node_key.
+func SetP2pIdentity(p2pCfg *config.P2pConf, cfg *PoaConfig) error {
+	if cfg.RemoteSigner != "" {
+		return errors.New("the validator key is in remote signer, it cannot be the libp2p key")
+	}
+	pub, priv, err := LoadKeyPair(cfg)
+	if err != nil {
+		return err
+	}
+	if pub.Type() != keypair.Ed25519 {
+		return errors.Errorf("%s key cannot be the libp2p key, only ed25519 can", pub.Type())
+	}
+	// libp2p generates the ed25519 key by reading its seed from node_key
+	p2pCfg.NodeKeyType = int(crypto.Ed25519)
+	p2pCfg.NodeKey = string(priv.Bytes()[:ed25519.SeedSize])
+	p2pCfg.NodeKeyFile = ""
+	return nil
+}
+
+// resolvePeerID returns the peer ID of the validator from p2pID, or from its pubkey if keyPeerID is true.
+// The peer ID of an ed25519 validator is derived from its pubkey whenever it is given, and they must match,
+// so a validator cannot be listed with the peer ID of another node. It returns empty if neither is given.
+func resolvePeerID(pubkey keypair.PubKey, p2pID string, keyPeerID bool) (peer.ID, error) {
+	var (
+		configured peer.ID
+		err        error
+	)
+	if p2pID != "" {
+		configured, err = peer.Decode(p2pID)
+		if err != nil {
+			return "", err
+		}
+	}
+	if !keyPeerID && (configured == "" || pubkey == nil || pubkey.Type() != keypair.Ed25519) {
+		return configured, nil
+	}
+	derived, err := PeerIDFromPubkey(pubkey)
+	if err != nil {
+		return "", err
+	}
+	if configured != "" && configured != derived {
+		return "", errors.Errorf("peer id(%s) of validator(%s) mismatches the one from its pubkey(%s)",
+			configured, pubkey.StringWithType(), derived)
+	}
+	return derived, nil
+}
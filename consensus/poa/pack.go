@@ -0,0 +1,97 @@
+package poa
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/yu-org/yu/core/types"
+)
+
+// the limits of a block on its txns
+const (
+	BlockSizeLimit = "size"
+	BlockCostLimit = "cost"
+)
+
+// TxnCostFn estimates the execution cost of a txn before it runs.
+// It must give the same cost on all validators, since they verify the blocks by it.
+type TxnCostFn func(txn *types.SignedTxn) uint64
+
+// SetTxnCost sets how the txns are costed against MaxBlockCost, every txn costs 1 by default.
+func (h *Poa) SetTxnCost(fn TxnCostFn) {
+	h.txnCost = fn
+}
+
+func unitTxnCost(*types.SignedTxn) uint64 {
+	return 1
+}
+
+func txnSize(txn *types.SignedTxn) (uint64, error) {
+	byt, err := txn.Encode()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(byt)), nil
+}
+
+// packLimit sums up the size and cost of the txns packed into a block.
+type packLimit struct {
+	maxSize uint64
+	maxCost uint64
+	costFn  TxnCostFn
+
+	size uint64
+	cost uint64
+	full bool
+}
+
+func (h *Poa) newPackLimit() *packLimit {
+	return &packLimit{
+		maxSize: h.cfg.MaxBlockSize,
+		maxCost: h.cfg.MaxBlockCost,
+		costFn:  h.txnCost,
+	}
+}
+
+// add returns true if txn fits in the block. Once a txn does not fit, the block is full and takes no more txns,
+// so the packed ones keep their order in the pool. A txn over the limits alone is left out, it never fits.
+func (l *packLimit) add(txn *types.SignedTxn) bool {
+	if l.full {
+		return false
+	}
+	size, err := txnSize(txn)
+	if err != nil {
+		logrus.Warnf("encode txn(%s) failed: %v", txn.TxnHash.String(), err)
+		return false
+	}
+	cost := l.costFn(txn)
+	if (l.maxSize > 0 && size > l.maxSize) || (l.maxCost > 0 && cost > l.maxCost) {
+		logrus.Warnf("txn(%s) of size(%d) cost(%d) is over the block limits", txn.TxnHash.String(), size, cost)
+		return false
+	}
+	if (l.maxSize > 0 && l.size+size > l.maxSize) || (l.maxCost > 0 && l.cost+cost > l.maxCost) {
+		l.full = true
+		return false
+	}
+	l.size += size
+	l.cost += cost
+	return true
+}
+
+// verifyBlockLimits checks the txns of a block from other leaders are within the block limits.
+func (h *Poa) verifyBlockLimits(block *types.Block) error {
+	var size, cost uint64
+	for _, txn := range block.Txns {
+		txnSize, err := txnSize(txn)
+		if err != nil {
+			return err
+		}
+		size += txnSize
+		cost += h.txnCost(txn)
+	}
+	if h.cfg.MaxBlockSize > 0 && size > h.cfg.MaxBlockSize {
+		return BlockOverLimit(block.Hash, BlockSizeLimit, size, h.cfg.MaxBlockSize)
+	}
+	if h.cfg.MaxBlockCost > 0 && cost > h.cfg.MaxBlockCost {
+		return BlockOverLimit(block.Hash, BlockCostLimit, cost, h.cfg.MaxBlockCost)
+	}
+	return nil
+}
@@ -1,12 +1,13 @@
 package poa
 
 import (
-	"bytes"
 	"fmt"
+	"github.com/cockroachdb/pebble"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/yu-org/nine-tripods/MEVless"
+	"github.com/yu-org/nine-tripods/utils/clock"
 	"github.com/yu-org/yu/common"
 	"github.com/yu-org/yu/common/yerror"
 	"github.com/yu-org/yu/core/keypair"
@@ -14,6 +15,7 @@ import (
 	"github.com/yu-org/yu/core/types"
 	"github.com/yu-org/yu/utils/log"
 	"go.uber.org/atomic"
+	"sync"
 	"time"
 )
 
@@ -22,20 +24,56 @@ type Poa struct {
 
 	MevLess *MEVless.MEVless `tripod:"mevless,omitempty"`
 
-	// key: crypto address, generate from pubkey
-	validatorsMap map[common.Address]peer.ID
-	myPubkey      keypair.PubKey
-	myPrivKey     keypair.PrivKey
+	// validator sets by the heights they take effect from
+	validators        *validatorSchedule
+	validatorsChanged bool
+	// the validators skipped by the leader schedule for missing slots
+	jails        *jailSchedule
+	jailsChanged bool
 
-	validatorsList []common.Address
+	myPubkey keypair.PubKey
+	signer   Signer
+	// refuses to sign a block conflicting with the signed ones, even after restart
+	guard *signGuard
 
 	currentHeight *atomic.Uint32
+	rounds        *roundState
+
+	votes        *voteCollector
+	finalizeLock sync.Mutex
+
+	evidences    *evidencePool
+	evidenceLock sync.Mutex
+
+	epochHooks []EpochHook
+
+	txnChecks []txnCheck
+	txnCost   TxnCostFn
+	nonces    *nonceTracker
+
+	halted *atomic.Bool
+	// set on a fatal failure of the block lifecycle
+	failed   *atomic.Bool
+	shutdown func(err error)
+	fatalCh  chan error
+	// closed by Close, it releases the block lifecycle parked after a fatal failure
+	stopCh   chan struct{}
+	stopOnce sync.Once
+
+	syncer     *syncState
+	syncServed *syncLimiter
+
+	db *pebble.DB
 
 	blockInterval int
 	packNum       uint64
-	recvChan      chan *types.Block
-	// local node index in addrs
-	nodeIdx int
+	received      *blockBuffer
+	// the block timing reads and waits on it
+	clock clock.Clock
+	// the timestamp the slots begin from, 0 until it is known
+	genesisTs *atomic.Uint64
+	// where the slots are counted from after the chain stalls, nil before that
+	anchor *atomic.Pointer[slotAnchor]
 
 	cfg *PoaConfig
 }
@@ -43,47 +81,76 @@ type Poa struct {
 type ValidatorInfo struct {
 	Pubkey keypair.PubKey
 	P2pID  peer.ID
+	// the share of proposing slots, 0 is treated as 1.
+	Weight uint64
 }
 
 func NewPoa(cfg *PoaConfig) *Poa {
-	pub, priv, infos, err := resolveConfig(cfg)
+	signer, infos, err := resolveConfig(cfg)
 	if err != nil {
 		logrus.Fatal("resolve poa config error: ", err)
 	}
-	return newPoa(pub, priv, infos, cfg)
+	return newPoa(signer, infos, cfg)
 }
 
-func newPoa(myPubkey keypair.PubKey, myPrivkey keypair.PrivKey, addrIps []ValidatorInfo, cfg *PoaConfig) *Poa {
+func newPoa(signer Signer, addrIps []ValidatorInfo, cfg *PoaConfig) *Poa {
 	tri := tripod.NewTripod()
 
-	var nodeIdx int
-
-	validatorsAddr := make([]common.Address, 0)
-	validators := make(map[common.Address]peer.ID)
-	for _, addrIp := range addrIps {
-		addr := addrIp.Pubkey.Address()
-		validators[addr] = addrIp.P2pID
-
-		if addr == myPubkey.Address() {
-			nodeIdx = len(validatorsAddr)
+	if cfg.DbPath == "" {
+		cfg.DbPath = DefaultDbPath
+	}
+	db, err := pebble.Open(cfg.DbPath, &pebble.Options{})
+	if err != nil {
+		logrus.Fatal("open poa db failed: ", err)
+	}
+	guard, err := newSignGuard(signBlock, &dbSignStore{db: db})
+	if err != nil {
+		logrus.Fatal("load the last signed block failed: ", err)
+	}
+	if cfg.SignGuardOverride > 0 {
+		overridden, err := guard.override(common.BlockNum(cfg.SignGuardOverride))
+		if err != nil {
+			logrus.Fatal("override sign guard failed: ", err)
+		}
+		if !overridden {
+			logrus.Warnf("sign_guard_override(%d) is ignored, no block is signed on it last time", cfg.SignGuardOverride)
 		}
-
-		validatorsAddr = append(validatorsAddr, addr)
 	}
 
 	p := &Poa{
-		Tripod:         tri,
-		validatorsMap:  validators,
-		validatorsList: validatorsAddr,
-		myPubkey:       myPubkey,
-		myPrivKey:      myPrivkey,
-		currentHeight:  atomic.NewUint32(0),
-		blockInterval:  cfg.BlockInterval,
-		packNum:        cfg.PackNum,
-		recvChan:       make(chan *types.Block, 10),
-		nodeIdx:        nodeIdx,
-		cfg:            cfg,
+		Tripod:        tri,
+		validators:    newValidatorSchedule(NewValidatorSet(1, addrIps)),
+		jails:         newJailSchedule(),
+		myPubkey:      signer.PubKey(),
+		signer:        signer,
+		guard:         guard,
+		currentHeight: atomic.NewUint32(0),
+		rounds:        newRoundState(),
+		votes:         newVoteCollector(),
+		evidences:     newEvidencePool(),
+		nonces:        newNonceTracker(),
+		txnCost:       unitTxnCost,
+		halted:        atomic.NewBool(false),
+		failed:        atomic.NewBool(false),
+		fatalCh:       make(chan error, 1),
+		stopCh:        make(chan struct{}),
+		syncer:        newSyncState(),
+		syncServed:    newSyncLimiter(),
+		db:            db,
+		blockInterval: cfg.BlockInterval,
+		packNum:       cfg.PackNum,
+		received:      newBlockBuffer(),
+		clock:         clock.Wall,
+		genesisTs:     atomic.NewUint64(cfg.GenesisTimestamp),
+		anchor:        atomic.NewPointer[slotAnchor](nil),
+		cfg:           cfg,
 	}
+	p.shutdown = p.report
+	p.SetWritings(p.AddValidator, p.RemoveValidator, p.Unjail)
+	p.SetReadings(p.QueryFinalityCert, p.QueryCheckpoint, p.QueryEvidences, p.QueryStateRootMismatches, p.QueryUptimes,
+		p.QueryValidators, p.QueryValidatorProposals, p.QueryLeaders, p.QueryNodeStatus)
+	p.SetP2pHandler(SyncBlocksCode, p.handleSyncRequest)
+	p.initTxnChecks()
 	//p.SetInit(p)
 	//p.SetTxnChecker(p)
 	//p.SetBlockCycle(p)
@@ -91,31 +158,123 @@ func newPoa(myPubkey keypair.PubKey, myPrivkey keypair.PrivKey, addrIps []Valida
 	return p
 }
 
-func (h *Poa) ValidatorsP2pID() (peers []peer.ID) {
-	for _, id := range h.validatorsMap {
-		peers = append(peers, id)
-	}
-	return
+func (h *Poa) ValidatorsP2pID() []peer.ID {
+	return h.ValidatorSetAt(h.getCurrentHeight()).P2pIDs()
 }
 
 func (h *Poa) LocalAddress() common.Address {
 	return h.myPubkey.Address()
 }
 
-func (h *Poa) CheckTxn(txn *types.SignedTxn) error {
-	// return metamask.CheckMetamaskSig(txn)
-	return nil
+// SignBlock signs the block header by the local validator,
+// unless it conflicts with the last block the validator signed, before or after restart.
+func (h *Poa) SignBlock(header *types.Header) ([]byte, error) {
+	return h.guard.sign(header, h.signer.SignBlock)
+}
+
+// Close releases the block lifecycle parked after a fatal failure, and closes the poa db.
+// Call it after the kernel stops, or while Kernel.Stop is waiting, so the kernel loop ends with the released block.
+func (h *Poa) Close() error {
+	h.release()
+	return h.db.Close()
+}
+
+func (h *Poa) release() {
+	h.stopOnce.Do(func() {
+		close(h.stopCh)
+	})
 }
 
 func (h *Poa) VerifyBlock(block *types.Block) error {
+	err := h.verifyProposer(block)
+	if err != nil {
+		return err
+	}
+
+	for _, txn := range block.Txns {
+		if hash := txnHash(txn); hash != txn.TxnHash {
+			return TxnHashMismatch(txn.TxnHash, hash)
+		}
+	}
+	if txnRoot := TxnRoot(block.Txns); txnRoot != block.TxnRoot {
+		return TxnRootMismatch(block.Hash, block.TxnRoot, txnRoot)
+	}
+	err = h.verifyBlockLimits(block)
+	if err != nil {
+		return err
+	}
+	if h.MevLess == nil {
+		err = h.verifyTxnOrder(block)
+		if err != nil {
+			return err
+		}
+	}
+	err = h.verifyBlockTxns(block)
+	if err != nil {
+		return err
+	}
+
+	parent, err := h.Chain.GetCompactBlock(block.PrevHash)
+	if err != nil {
+		if errors.Is(err, yerror.ErrBlockNotFound) {
+			return ParentNotFound(block.Hash, block.PrevHash)
+		}
+		return err
+	}
+	if parent.Height+1 != block.Height {
+		return HeightNotContinuous(block.Hash, block.Height, parent.Height)
+	}
+
+	// genesis is made by every node locally, its timestamp is not comparable.
+	var minTimestamp uint64
+	if parent.Height > 0 {
+		minTimestamp = parent.Timestamp
+	}
+	// the slots are comparable between validators on the shared genesis timestamp,
+	// a block cannot be stamped before its slot, beyond the clock drift.
+	if h.cfg.GenesisTimestamp > 0 {
+		genesisSlot, err := h.genesisSlot(block.Height)
+		if err != nil {
+			return err
+		}
+		slot := uint64(genesisSlot.Unix())
+		if slot > minTimestamp+h.maxClockDrift() {
+			minTimestamp = slot - h.maxClockDrift()
+		}
+	}
+	maxTimestamp := h.nowTs() + h.maxClockDrift()
+	if block.Timestamp < minTimestamp || block.Timestamp > maxTimestamp {
+		return TimestampOutOfRange(block.Hash, block.Timestamp, minTimestamp, maxTimestamp)
+	}
+	return nil
+}
+
+// verifyProposer checks the block is signed by the leader of its height and round.
+func (h *Poa) verifyProposer(block *types.Block) error {
 	minerPubkey, err := keypair.PubKeyFromBytes(block.MinerPubkey)
 	if err != nil {
 		logrus.Warnf("parse pubkey(%s) error: %v", block.MinerPubkey, err)
 		return err
 	}
-	if _, ok := h.validatorsMap[minerPubkey.Address()]; !ok {
+	miner := minerPubkey.Address()
+	if !h.ValidatorSetAt(block.Height).Contains(miner) {
 		logrus.Warn("illegal miner: ", minerPubkey.StringWithType())
-		return errors.Errorf("miner(%s) is not validator", minerPubkey.Address())
+		return MinerNotValidator(miner, block.Height)
+	}
+	round := BlockRound(block)
+	leader, err := h.LeaderOf(block.Height, block.PrevHash, round)
+	if errors.Is(err, yerror.ErrBlockNotFound) {
+		return ParentNotFound(block.Hash, block.PrevHash)
+	}
+	if err != nil {
+		return err
+	}
+	if leader != miner {
+		return MinerNotLeader(miner, leader, block.Height, round)
+	}
+
+	if hash := HeaderHash(block.Header); hash != block.Hash {
+		return BlockHashMismatch(block.Hash, hash)
 	}
 	if !minerPubkey.VerifySignature(block.Hash.Bytes(), block.MinerSignature) {
 		return yerror.BlockSignatureIllegal(block.Hash)
@@ -124,158 +283,192 @@ func (h *Poa) VerifyBlock(block *types.Block) error {
 }
 
 func (h *Poa) InitChain(block *types.Block) {
+	err := h.reloadValidators()
+	if err != nil {
+		logrus.Fatal("load validators from state failed: ", err)
+	}
+	err = h.reloadJails()
+	if err != nil {
+		logrus.Fatal("load jailed validators from state failed: ", err)
+	}
 
-	go func() {
-		for {
-			msg, err := h.P2pNetwork.SubP2P(common.StartBlockTopic)
-			if err != nil {
-				logrus.Error("subscribe message from P2P error: ", err)
-				continue
-			}
-			p2pBlock, err := types.DecodeBlock(msg)
-			if err != nil {
-				logrus.Error("decode p2pBlock from p2p error: ", err)
-				continue
-			}
-			if bytes.Equal(p2pBlock.MinerPubkey, h.myPubkey.BytesWithType()) {
-				continue
-			}
-
-			logrus.Debugf("accept block(%s), height(%d), miner(%s)",
-				p2pBlock.Hash.String(), p2pBlock.Height, common.ToHex(p2pBlock.MinerPubkey))
-
-			if h.getCurrentHeight() > p2pBlock.Height {
-				continue
-			}
-
-			err = h.RangeList(func(tri *tripod.Tripod) error {
-				return tri.BlockVerifier.VerifyBlock(block)
-			})
-			if err != nil {
-				logrus.Warnf("p2pBlock(%s) verify failed: %s", p2pBlock.Hash, err)
-				continue
-			}
+	h.P2pNetwork.AddTopic(RoundChangeTopic)
+	h.P2pNetwork.AddTopic(VoteTopic)
+	h.P2pNetwork.AddTopic(EvidenceTopic)
+	go h.handleRoundChanges()
+	go h.handleVotes()
+	go h.handleEvidences()
+	go h.handleBlocks()
 
-			h.recvChan <- p2pBlock
-		}
-	}()
+	// the admission checks apply to the txns of all tripods
+	h.Pool.WithBaseCheck(h)
 }
 
 func (h *Poa) StartBlock(block *types.Block) {
-	now := time.Now()
-	defer func() {
-		duration := time.Since(now)
-		// fmt.Println("-------start-block last: ", duration.String(), "block-number = ", block.Height)
-		time.Sleep(time.Duration(h.blockInterval)*time.Millisecond - duration)
-	}()
+	if h.parkIfFailed() {
+		return
+	}
+	h.waitIfHalted()
 
 	h.setCurrentHeight(block.Height)
+	err := h.waitSlot(block)
+	if err != nil {
+		h.fail(block, SlotStage, err)
+		h.parkIfFailed()
+		return
+	}
 
 	if h.cfg.PrettyLog {
 		log.StarConsole.Info(fmt.Sprintf("start a new block, height=%d", block.Height))
 	}
 
-	if !h.AmILeader(block.Height) {
+	h.rounds.reset(block.Height, weakQuorum(h.ValidatorSetAt(block.Height).Len()))
+	for {
 		if h.useP2pOrSkip(block) {
-			logrus.Infof("--------USE P2P Height(%d) block(%s) miner(%s)",
-				block.Height, block.Hash.String(), common.ToHex(block.MinerPubkey))
+			if h.syncer.replays(block) {
+				logrus.Infof("--------USE SYNCED Height(%d) block(%s)", block.Height, block.Hash.String())
+				return
+			}
+			h.observeLateness(block, receivedBlock)
+			logrus.Infof("--------USE P2P Height(%d) Round(%d) block(%s) miner(%s)",
+				block.Height, BlockRound(block), block.Hash.String(), common.ToHex(block.MinerPubkey))
 			return
 		}
-	}
 
-	logrus.Infof(" I am Leader! I mine the block for height (%d)! ", block.Height)
-	var (
-		txns []*types.SignedTxn
-		err  error
-	)
-
-	if h.MevLess != nil {
-		txns, err = h.MevLess.Pack(block.Height, h.packNum)
-	} else {
-		txns, err = h.Pool.Pack(h.packNum)
-	}
+		logrus.Infof(" I am Leader! I mine the block for height (%d) round (%d)! ", block.Height, BlockRound(block))
+		var (
+			txns []*types.SignedTxn
+			err  error
+		)
+
+		// fill the block up to whichever limit comes first
+		filter := h.packFilter(h.newPackLimit())
+		if h.MevLess != nil {
+			txns, err = h.MevLess.PackFor(block.Height, h.packNum, filter)
+		} else {
+			h.Pool.SortTxns(h.orderTxns)
+			txns, err = h.Pool.PackFor(h.packNum, filter)
+		}
 
-	if err != nil {
-		logrus.Panic("pack txns from pool: ", err)
-	}
+		if err != nil {
+			h.skipSlot(block, PackStage, err)
+			continue
+		}
 
-	// logrus.Info("---- the num of pack txns is ", len(txns))
+		// logrus.Info("---- the num of pack txns is ", len(txns))
 
-	txnRoot, err := types.MakeTxnRoot(txns)
-	if err != nil {
-		logrus.Panic("make txn-root failed: ", err)
-	}
-	block.TxnRoot = txnRoot
+		block.TxnRoot = TxnRoot(txns)
+		block.Timestamp = h.nowTs()
 
-	byt, _ := block.Encode()
-	block.Hash = common.BytesToHash(common.Sha256(byt))
+		block.Hash = HeaderHash(block.Header)
 
-	// miner signs block
-	block.MinerSignature, err = h.myPrivKey.SignData(block.Hash.Bytes())
-	if err != nil {
-		logrus.Panic("sign block failed: ", err)
-	}
-	block.MinerPubkey = h.myPubkey.BytesWithType()
-
-	block.SetTxns(txns)
+		// miner signs block
+		block.MinerSignature, err = h.SignBlock(block.Header)
+		if err != nil {
+			// the signer refuses to sign twice on one height and round, or it is unreachable,
+			// leave the height to the leader of next round.
+			logrus.Errorf("sign block of height(%d) round(%d) failed: %v", block.Height, BlockRound(block), err)
+			var refused ErrSignRefused
+			if errors.As(err, &refused) {
+				logrus.Warnf("set sign_guard_override = %d to sign on it again, only if the signed block was never published",
+					refused.SignedHeight)
+			}
+			h.enterRound(block.Height, BlockRound(block)+1)
+			continue
+		}
+		block.MinerPubkey = h.myPubkey.BytesWithType()
 
-	h.State.StartBlock(block)
+		block.SetTxns(txns)
 
-	blockByt, err := block.Encode()
-	if err != nil {
-		logrus.Panic("encode raw-block failed: ", err)
-	}
+		blockByt, err := block.Encode()
+		if err != nil {
+			h.skipSlot(block, EncodeStage, err)
+			continue
+		}
 
-	err = h.P2pNetwork.PubP2P(common.StartBlockTopic, blockByt)
-	if err != nil {
-		logrus.Panic("publish block to p2p failed: ", err)
+		err = h.retry(block, PublishStage, func() error {
+			return h.P2pNetwork.PubP2P(common.StartBlockTopic, blockByt)
+		})
+		if err != nil {
+			// the validators which miss the block move to the next round, so does the leader.
+			h.skipSlot(block, PublishStage, err)
+			continue
+		}
+		h.State.StartBlock(block)
+		h.observeLateness(block, proposedBlock)
+		return
 	}
 }
 
 func (h *Poa) EndBlock(block *types.Block) {
+	if h.failed.Load() {
+		return
+	}
 	chain := h.Chain
 
 	// now := time.Now()
-	err := h.Execute(block)
+	err := h.execute(block)
 	if err != nil {
-		logrus.Panic("execute block failed: ", err)
+		h.fail(block, ExecuteStage, err)
+		return
 	}
-	// TODO: sync the state (execute receipt) with other nodes
 
 	err = chain.AppendBlock(block)
 	if err != nil {
-		logrus.Panic("append block failed: ", err)
+		h.fail(block, AppendStage, err)
+		return
 	}
+	h.checkStateRoots(block)
 	// fmt.Println("execute block last: ", time.Since(now).String())
 
-	err = h.Pool.Reset(block.Txns)
+	err = h.retry(block, ResetPoolStage, func() error {
+		return h.Pool.Reset(block.Txns)
+	})
 	if err != nil {
-		logrus.Panic("reset pool failed: ", err)
+		// the packed txns stay in the pool and may be packed again,
+		// the validators with the nonce check reject such blocks.
+		logrus.Error(err)
 	}
 
+	h.endEpoch(block)
+
 	// log.PlusLog().Info(fmt.Sprintf("append block, height=%d, hash=%s", block.Height, block.Hash.String()))
 
 	//logrus.WithField("block-height", block.Height).WithField("block-hash", block.Hash.String()).
 	//	Info("append block")
 
-	h.State.FinalizeBlock(block)
+	err = h.vote(block)
+	if err != nil {
+		logrus.Error("vote for block failed: ", err)
+	}
 }
 
-func (h *Poa) FinalizeBlock(block *types.Block) {
-	//logrus.WithField("block-height", block.Height).WithField("block-hash", block.Hash.String()).
-	//	Info("finalize block")
-
-	if h.cfg.PrettyLog {
-		log.DoubleLineConsole.Info(fmt.Sprintf("finalize block, height=%d, hash=%s", block.Height, block.Hash.String()))
+// execute runs the txns of block and sets its receipt root.
+func (h *Poa) execute(block *types.Block) error {
+	err := h.Execute(block)
+	if err != nil {
+		return err
 	}
-	h.Chain.Finalize(block)
+	block.ReceiptRoot, err = h.receiptRoot(block)
+	return err
 }
 
-func (h *Poa) CompeteLeader(blockHeight common.BlockNum) common.Address {
-	idx := (int(blockHeight) - 1) % len(h.validatorsList)
-	leader := h.validatorsList[idx]
-	logrus.Debugf("compete a leader(%s) in round(%d)", leader.String(), blockHeight)
-	return leader
+// FinalizeBlock does not finalize the block at once,
+// it waits until more than 2/3 validators vote for the block.
+func (h *Poa) FinalizeBlock(block *types.Block) {
+	if h.failed.Load() {
+		return
+	}
+	if cert := h.syncer.takeReplaying(block); cert != nil {
+		for _, vote := range cert.Votes {
+			voter, _ := vote.Voter()
+			h.checkStateRoot(block.Header, voter, vote)
+		}
+		h.finalizeWithCert(block, cert)
+		return
+	}
+	h.votes.addBlock(block)
+	h.tryFinalize()
 }
 
 func (h *Poa) AmILeader(blockHeight common.BlockNum) bool {
@@ -283,33 +476,31 @@ func (h *Poa) AmILeader(blockHeight common.BlockNum) bool {
 }
 
 func (h *Poa) IsValidator(addr common.Address) bool {
-	_, ok := h.validatorsMap[addr]
-	return ok
+	return h.ValidatorSetAt(h.getCurrentHeight()).Contains(addr)
 }
 
-func (h *Poa) useP2pOrSkip(localBlock *types.Block) bool {
-LOOP:
-	select {
-	case p2pBlock := <-h.recvChan:
-		if h.getCurrentHeight() > p2pBlock.Height {
-			goto LOOP
-		}
-		localBlock.CopyFrom(p2pBlock)
-		h.State.StartBlock(localBlock)
-		return true
-	case <-time.NewTicker(h.calculateWaitTime(localBlock)).C:
-		return false
+func (h *Poa) maxClockDrift() uint64 {
+	if h.cfg.MaxClockDrift > 0 {
+		return h.cfg.MaxClockDrift
 	}
+	return DefaultMaxClockDrift
 }
 
-func (h *Poa) calculateWaitTime(block *types.Block) time.Duration {
-	//height := int(block.Height)
-	//shouldLeaderIdx := (height - 1) % len(h.validatorsList)
-	//n := shouldLeaderIdx - h.nodeIdx
-	//if n < 0 {
-	//	n = -n
-	//}
+// WithClock makes the block timing read and wait on clk instead of the wall clock,
+// it must be called before the chain starts.
+func (h *Poa) WithClock(clk clock.Clock) {
+	h.clock = clk
+}
 
+// nowTs returns the unix timestamp in seconds by the clock, as yu stamps blocks.
+func (h *Poa) nowTs() uint64 {
+	return uint64(h.clock.Now().Unix())
+}
+
+func (h *Poa) calculateWaitTime(block *types.Block) time.Duration {
+	if h.cfg.RoundTimeout > 0 {
+		return time.Duration(h.cfg.RoundTimeout) * time.Millisecond
+	}
 	return time.Duration(h.blockInterval) * time.Millisecond
 }
 
@@ -320,3 +511,25 @@ func (h *Poa) getCurrentHeight() common.BlockNum {
 func (h *Poa) setCurrentHeight(height common.BlockNum) {
 	h.currentHeight.Store(uint32(height))
 }
+
+// HeaderHash computes the block hash the way the leader builds it in StartBlock,
+// the fields set after signing or by execution are not included.
+func HeaderHash(header *types.Header) common.Hash {
+	h := *header
+	h.Hash = common.NullHash
+	h.StateRoot = common.NullHash
+	h.ReceiptRoot = common.NullHash
+	h.LeiUsed = 0
+	h.MinerPubkey = nil
+	h.MinerSignature = nil
+	byt, _ := (&types.Block{Header: &h}).Encode()
+	return common.BytesToHash(common.Sha256(byt))
+}
+
+// txnHash computes the hash of the txn the way types.NewSignedTxn builds it.
+func txnHash(txn *types.SignedTxn) common.Hash {
+	t := *txn
+	t.TxnHash = common.NullHash
+	hash, _ := t.GenerateHash()
+	return hash
+}
@@ -0,0 +1,134 @@
+package poa
+
+import (
+	"github.com/pkg/errors"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/context"
+	"net/http"
+)
+
+// the max number of heights in one leaders query
+const maxLeaderRange = 1024
+
+// ValidatorEntry is a validator shown by the queries.
+type ValidatorEntry struct {
+	Address common.Address `json:"address"`
+	Pubkey  string         `json:"pubkey"`
+	P2pID   string         `json:"p2p_id,omitempty"`
+	Weight  uint64         `json:"weight,omitempty"`
+	// skipped by the leader schedule
+	Jailed bool `json:"jailed"`
+}
+
+// GetValidators returns the validators working on height, 0 is the current height.
+func (h *Poa) GetValidators(height common.BlockNum) []*ValidatorEntry {
+	if height == 0 {
+		height = h.getCurrentHeight()
+	}
+	set := h.ValidatorSetAt(height)
+	leaders := h.LeadersAt(height)
+	validators := make([]*ValidatorEntry, 0, set.Len())
+	for _, addr := range set.Addrs {
+		info := set.Infos[addr]
+		validator := &ValidatorEntry{
+			Address: addr,
+			Pubkey:  info.Pubkey.StringWithType(),
+			Weight:  info.Weight,
+			Jailed:  !leaders.Contains(addr),
+		}
+		if info.P2pID != "" {
+			validator.P2pID = info.P2pID.String()
+		}
+		validators = append(validators, validator)
+	}
+	return validators
+}
+
+func (h *Poa) QueryValidators(ctx *context.ReadContext) {
+	var req HeightRequest
+	err := ctx.BindJson(&req)
+	if err != nil {
+		ctx.Err(http.StatusBadRequest, err)
+		return
+	}
+	ctx.JsonOk(h.GetValidators(req.Height))
+}
+
+// HeightLeader is the leader of the first round of a height.
+type HeightLeader struct {
+	Height common.BlockNum `json:"height"`
+	// the zero address if it is unknown yet
+	Leader common.Address `json:"leader"`
+}
+
+type HeightRangeRequest struct {
+	From common.BlockNum `json:"from"`
+	To   common.BlockNum `json:"to"`
+}
+
+// GetLeaders returns the leaders of the heights from `from` to `to`, both included.
+// The leaders of the future heights are predicted by the validator changes and jails known now,
+// the beacon leaders are unknown until their parents are produced.
+func (h *Poa) GetLeaders(from, to common.BlockNum) ([]*HeightLeader, error) {
+	if from == 0 || to < from {
+		return nil, errors.Errorf("illegal height range [%d, %d]", from, to)
+	}
+	if to-from >= maxLeaderRange {
+		return nil, errors.Errorf("height range [%d, %d] is larger than %d", from, to, maxLeaderRange)
+	}
+	end, err := h.Chain.GetEndCompactBlock()
+	if err != nil {
+		return nil, err
+	}
+	leaders := make([]*HeightLeader, 0, to-from+1)
+	for height := from; height <= to; height++ {
+		leader := &HeightLeader{Height: height}
+		if h.cfg.LeaderElection != BeaconElection || height <= end.Height+1 {
+			leader.Leader = h.CompeteLeader(height)
+		}
+		leaders = append(leaders, leader)
+	}
+	return leaders, nil
+}
+
+func (h *Poa) QueryLeaders(ctx *context.ReadContext) {
+	var req HeightRangeRequest
+	err := ctx.BindJson(&req)
+	if err != nil {
+		ctx.Err(http.StatusBadRequest, err)
+		return
+	}
+	leaders, err := h.GetLeaders(req.From, req.To)
+	if err != nil {
+		ctx.Err(http.StatusBadRequest, err)
+		return
+	}
+	ctx.JsonOk(leaders)
+}
+
+// NodeStatus is the role and progress of the local node.
+type NodeStatus struct {
+	Address common.Address `json:"address"`
+	Pubkey  string         `json:"pubkey"`
+	// the index of the local node in the validators of the current height, -1 if it is not a validator
+	Index       int  `json:"index"`
+	IsValidator bool `json:"is_validator"`
+	// the height the node is producing or verifying
+	Height common.BlockNum `json:"height"`
+}
+
+func (h *Poa) GetNodeStatus() *NodeStatus {
+	height := h.getCurrentHeight()
+	index := h.ValidatorSetAt(height).Index(h.LocalAddress())
+	return &NodeStatus{
+		Address:     h.LocalAddress(),
+		Pubkey:      h.myPubkey.StringWithType(),
+		Index:       index,
+		IsValidator: index >= 0,
+		Height:      height,
+	}
+}
+
+func (h *Poa) QueryNodeStatus(ctx *context.ReadContext) {
+	ctx.JsonOk(h.GetNodeStatus())
+}
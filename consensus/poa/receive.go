@@ -0,0 +1,188 @@
+package poa
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/tripod"
+	"github.com/yu-org/yu/core/types"
+	"sort"
+	"sync"
+)
+
+const (
+	// how many heights after the local chain end the p2p blocks can be buffered,
+	// the blocks further away are fetched by catching up.
+	blockBufferHeights = 64
+	// the max number of p2p blocks buffered for one height
+	maxBlocksPerHeight = 32
+)
+
+// blockBuffer keeps the p2p blocks by height until the local node comes to their height.
+type blockBuffer struct {
+	sync.Mutex
+	blocks map[common.BlockNum][]*types.Block
+	// hashes of the blocks ever buffered, by height
+	seen map[common.BlockNum]map[common.Hash]struct{}
+	// notify the waiting loop that a block arrives
+	arriveCh chan struct{}
+}
+
+func newBlockBuffer() *blockBuffer {
+	return &blockBuffer{
+		blocks:   make(map[common.BlockNum][]*types.Block),
+		seen:     make(map[common.BlockNum]map[common.Hash]struct{}),
+		arriveCh: make(chan struct{}, 1),
+	}
+}
+
+// add buffers the block, end is the height of the local chain end.
+func (bb *blockBuffer) add(block *types.Block, end common.BlockNum) error {
+	bb.Lock()
+	defer bb.Unlock()
+	bb.prune(end + 1)
+
+	if block.Height <= end {
+		return StaleBlock(block.Hash, block.Height, end)
+	}
+	if block.Height > end+blockBufferHeights {
+		return BlockTooFarAhead(block.Hash, block.Height, end)
+	}
+	seen, ok := bb.seen[block.Height]
+	if !ok {
+		seen = make(map[common.Hash]struct{})
+		bb.seen[block.Height] = seen
+	}
+	if _, ok = seen[block.Hash]; ok {
+		return DuplicateBlock(block.Hash, block.Height)
+	}
+	if len(seen) >= maxBlocksPerHeight {
+		return errors.Errorf("too many blocks on height(%d), drop block(%s)", block.Height, block.Hash.String())
+	}
+	seen[block.Hash] = struct{}{}
+	bb.blocks[block.Height] = append(bb.blocks[block.Height], block)
+
+	select {
+	case bb.arriveCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// take removes and returns the usable buffered blocks of height, in the order of their rounds.
+// The others stay buffered.
+func (bb *blockBuffer) take(height common.BlockNum, usable func(*types.Block) bool) []*types.Block {
+	bb.Lock()
+	defer bb.Unlock()
+	bb.prune(height)
+
+	var taken, kept []*types.Block
+	for _, block := range bb.blocks[height] {
+		if usable(block) {
+			taken = append(taken, block)
+		} else {
+			kept = append(kept, block)
+		}
+	}
+	if len(kept) > 0 {
+		bb.blocks[height] = kept
+	} else {
+		delete(bb.blocks, height)
+	}
+	sort.SliceStable(taken, func(i, j int) bool {
+		return BlockRound(taken[i]) < BlockRound(taken[j])
+	})
+	return taken
+}
+
+// prune drops everything below height.
+func (bb *blockBuffer) prune(height common.BlockNum) {
+	for h := range bb.seen {
+		if h < height {
+			delete(bb.seen, h)
+			delete(bb.blocks, h)
+		}
+	}
+}
+
+func (h *Poa) handleBlocks() {
+	for {
+		msg, err := h.P2pNetwork.SubP2P(common.StartBlockTopic)
+		if err != nil {
+			logrus.Error("subscribe message from P2P error: ", err)
+			continue
+		}
+		if h.failed.Load() {
+			continue
+		}
+		p2pBlock, err := types.DecodeBlock(msg)
+		if err != nil {
+			logrus.Error("decode p2pBlock from p2p error: ", err)
+			continue
+		}
+		if bytes.Equal(p2pBlock.MinerPubkey, h.myPubkey.BytesWithType()) {
+			continue
+		}
+
+		logrus.Debugf("accept block(%s), height(%d), miner(%s)",
+			p2pBlock.Hash.String(), p2pBlock.Height, common.ToHex(p2pBlock.MinerPubkey))
+
+		h.checkDoubleSign(p2pBlock.Header)
+
+		err = h.ReceiveBlock(p2pBlock)
+		switch {
+		case err == nil:
+		case errors.As(err, &ErrStaleBlock{}), errors.As(err, &ErrDuplicateBlock{}):
+			logrus.Debug("drop p2p block: ", err)
+		default:
+			logrus.Warn("reject p2p block: ", err)
+		}
+	}
+}
+
+// ReceiveBlock checks the block proposed by another validator and buffers it
+// until the local node comes to its height, where it is fully verified.
+func (h *Poa) ReceiveBlock(block *types.Block) error {
+	err := h.verifyProposer(block)
+	// the parent may be on the way, the leader is checked again with the parent in VerifyBlock.
+	if err != nil && !errors.As(err, &ErrParentNotFound{}) {
+		return err
+	}
+	end, err := h.Chain.GetEndCompactBlock()
+	if err != nil {
+		return err
+	}
+	return h.received.add(block, end.Height)
+}
+
+// useReceived copies the first valid p2p block of localBlock.Height into localBlock.
+// The blocks of the rounds the local validator has left by a round-change are not used,
+// unless weakQuorum validators have voted for them, then no other block of the height can be finalized.
+func (h *Poa) useReceived(localBlock *types.Block) bool {
+	locked := h.rounds.lockedRound()
+	usable := func(block *types.Block) bool {
+		if BlockRound(block) >= locked {
+			return true
+		}
+		voters := len(h.votes.votesOf(block.Height, block.Hash))
+		return voters >= weakQuorum(h.ValidatorSetAt(block.Height).Len())
+	}
+	for _, p2pBlock := range h.received.take(localBlock.Height, usable) {
+		if p2pBlock.PrevHash != localBlock.PrevHash {
+			logrus.Debugf("drop p2p block(%s) on another parent(%s)", p2pBlock.Hash.String(), p2pBlock.PrevHash.String())
+			continue
+		}
+		err := h.RangeList(func(tri *tripod.Tripod) error {
+			return tri.BlockVerifier.VerifyBlock(p2pBlock)
+		})
+		if err != nil {
+			logrus.Warnf("p2pBlock(%s) verify failed: %s", p2pBlock.Hash.String(), err)
+			continue
+		}
+		localBlock.CopyFrom(p2pBlock)
+		h.State.StartBlock(localBlock)
+		return true
+	}
+	return false
+}
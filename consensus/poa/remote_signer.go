@@ -0,0 +1,217 @@
+package poa
+
+import (
+	"github.com/pkg/errors"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"sync"
+	"time"
+)
+
+const (
+	signerService = "Signer"
+	signerTimeout = 5 * time.Second
+)
+
+type SignBlockArgs struct {
+	// the header encoded as a block without txns
+	Header []byte `json:"header"`
+}
+
+type SignReply struct {
+	Signature []byte `json:"signature"`
+}
+
+// RemoteSigner asks a SignerServer to sign over a unix socket, so the validator key is not on the consensus host.
+// It redials the socket when the connection is broken.
+type RemoteSigner struct {
+	sync.Mutex
+	path   string
+	client *rpc.Client
+	pubkey keypair.PubKey
+}
+
+// DialRemoteSigner connects the SignerServer listening on the unix socket path and fetches its pubkey.
+func DialRemoteSigner(path string) (*RemoteSigner, error) {
+	s := &RemoteSigner{path: path}
+	var pubkey string
+	err := s.call("PubKey", struct{}{}, &pubkey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connect remote signer(%s)", path)
+	}
+	s.pubkey, err = keypair.PubkeyFromStr(pubkey)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *RemoteSigner) call(method string, args, reply any) error {
+	s.Lock()
+	defer s.Unlock()
+	reused := s.client != nil
+	err := s.tryCall(method, args, reply)
+	var serverErr rpc.ServerError
+	if err != nil && reused && !errors.As(err, &serverErr) {
+		// the signer may have restarted, signing the same block again is safe
+		err = s.tryCall(method, args, reply)
+	}
+	return err
+}
+
+func (s *RemoteSigner) tryCall(method string, args, reply any) error {
+	if s.client == nil {
+		conn, err := net.DialTimeout("unix", s.path, signerTimeout)
+		if err != nil {
+			return err
+		}
+		s.client = jsonrpc.NewClient(conn)
+	}
+
+	timer := time.NewTimer(signerTimeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case call := <-s.client.Go(signerService+"."+method, args, reply, make(chan *rpc.Call, 1)).Done:
+		err = call.Error
+	case <-timer.C:
+		err = errors.Errorf("remote signer(%s) does not answer in %s", s.path, signerTimeout)
+	}
+	var serverErr rpc.ServerError
+	if err != nil && !errors.As(err, &serverErr) {
+		// the connection is broken or stuck, dial again next time
+		s.client.Close()
+		s.client = nil
+	}
+	return err
+}
+
+func (s *RemoteSigner) PubKey() keypair.PubKey {
+	return s.pubkey
+}
+
+func (s *RemoteSigner) SignBlock(header *types.Header) ([]byte, error) {
+	byt, err := (&types.Block{Header: header}).Encode()
+	if err != nil {
+		return nil, err
+	}
+	reply := new(SignReply)
+	err = s.call("SignBlock", &SignBlockArgs{Header: byt}, reply)
+	return reply.Signature, err
+}
+
+func (s *RemoteSigner) SignVote(vote *Vote) ([]byte, error) {
+	reply := new(SignReply)
+	err := s.call("SignVote", vote, reply)
+	return reply.Signature, err
+}
+
+func (s *RemoteSigner) SignRoundChange(rc *RoundChange) ([]byte, error) {
+	reply := new(SignReply)
+	err := s.call("SignRoundChange", rc, reply)
+	return reply.Signature, err
+}
+
+// SignerServer serves the Signer to the Poa node, like tmkms.
+// It keeps the high-water marks of the signed blocks, votes and round-changes in files,
+// so it never signs two of a kind on one height and round, nor one below the mark, even after it restarts.
+type SignerServer struct {
+	signer     Signer
+	guard      *signGuard
+	voteGuard  *signGuard
+	roundGuard *signGuard
+}
+
+// NewSignerServer loads the high-water mark of blocks from statePath, and the ones of votes and round-changes
+// from statePath with the suffix ".vote" and ".round_change". The files are created on the first signing.
+func NewSignerServer(signer Signer, statePath string) (*SignerServer, error) {
+	guard, err := newSignGuard(signBlock, &fileSignStore{path: statePath})
+	if err != nil {
+		return nil, err
+	}
+	voteGuard, err := newSignGuard(signVote, &fileSignStore{path: statePath + ".vote"})
+	if err != nil {
+		return nil, err
+	}
+	roundGuard, err := newSignGuard(signRoundChange, &fileSignStore{path: statePath + ".round_change"})
+	if err != nil {
+		return nil, err
+	}
+	return &SignerServer{signer: signer, guard: guard, voteGuard: voteGuard, roundGuard: roundGuard}, nil
+}
+
+// Override forgets the high-water mark of blocks if it is on height, see PoaConfig.SignGuardOverride.
+func (s *SignerServer) Override(height common.BlockNum) (bool, error) {
+	return s.guard.override(height)
+}
+
+func (s *SignerServer) PubKey() keypair.PubKey {
+	return s.signer.PubKey()
+}
+
+// SignBlock signs the block above the high-water mark, and moves the mark onto it before returning the signature.
+// The same block on the mark is signed again, in case the node lost the signature.
+func (s *SignerServer) SignBlock(header *types.Header) ([]byte, error) {
+	return s.guard.sign(header, s.signer.SignBlock)
+}
+
+// SignVote signs the vote above the height of the last signed one, or the same vote again.
+func (s *SignerServer) SignVote(vote *Vote) ([]byte, error) {
+	return s.voteGuard.signVote(vote, s.signer.SignVote)
+}
+
+// SignRoundChange signs the round-change above the height and round of the last signed one, or the same one again.
+func (s *SignerServer) SignRoundChange(rc *RoundChange) ([]byte, error) {
+	return s.roundGuard.signRoundChange(rc, s.signer.SignRoundChange)
+}
+
+// Serve answers the RemoteSigners connected from the listener until it is closed.
+func (s *SignerServer) Serve(listener net.Listener) error {
+	server := rpc.NewServer()
+	err := server.RegisterName(signerService, &signerRPC{signer: s})
+	if err != nil {
+		return err
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// signerRPC adapts the Signer to the methods of net/rpc.
+type signerRPC struct {
+	signer Signer
+}
+
+func (r *signerRPC) PubKey(_ struct{}, reply *string) error {
+	*reply = r.signer.PubKey().StringWithType()
+	return nil
+}
+
+func (r *signerRPC) SignBlock(args *SignBlockArgs, reply *SignReply) error {
+	block, err := types.DecodeBlock(args.Header)
+	if err != nil {
+		return err
+	}
+	reply.Signature, err = r.signer.SignBlock(block.Header)
+	return err
+}
+
+func (r *signerRPC) SignVote(vote *Vote, reply *SignReply) error {
+	var err error
+	reply.Signature, err = r.signer.SignVote(vote)
+	return err
+}
+
+func (r *signerRPC) SignRoundChange(rc *RoundChange, reply *SignReply) error {
+	var err error
+	reply.Signature, err = r.signer.SignRoundChange(rc)
+	return err
+}
@@ -0,0 +1,350 @@
+package poa
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"sort"
+	"sync"
+	"time"
+)
+
+const RoundChangeTopic = "poa-round-change"
+
+// the round-changes for heights further than it ahead of the local one are dropped,
+// a validator cannot fill the memory with them.
+const roundHeightsAhead = 4
+
+// the round-changes for rounds further than it ahead of the local one are dropped,
+// the honest validators move to the rounds together and stay far within it.
+const roundsAhead = 256
+
+// RoundChange is broadcast by a validator when the leader of its current round
+// does not propose in time, and it moves to Round.
+type RoundChange struct {
+	Height    common.BlockNum `json:"height"`
+	Round     uint64          `json:"round"`
+	Pubkey    []byte          `json:"pubkey"`
+	Signature []byte          `json:"signature"`
+}
+
+func (rc *RoundChange) SignHash() []byte {
+	byt, _ := json.Marshal(struct {
+		Height common.BlockNum `json:"height"`
+		Round  uint64          `json:"round"`
+	}{rc.Height, rc.Round})
+	return common.Sha256(byt)
+}
+
+func (rc *RoundChange) Encode() ([]byte, error) {
+	return json.Marshal(rc)
+}
+
+func DecodeRoundChange(byt []byte) (*RoundChange, error) {
+	rc := new(RoundChange)
+	err := json.Unmarshal(byt, rc)
+	return rc, err
+}
+
+// roundState tracks the round of the height being produced
+// and the round-changes received from other validators.
+type roundState struct {
+	sync.Mutex
+	height common.BlockNum
+	round  uint64
+	// the highest round of the height the local validator has sent a round-change for,
+	// the blocks of lower rounds are not accepted since then, as weakQuorum validators may have moved on without them.
+	locked uint64
+	// the highest round each validator has moved to, by height
+	changes map[common.BlockNum]map[common.Address]uint64
+	// notify the waiting loop that enough validators have moved to a higher round
+	jumpCh chan uint64
+	// notify the waiting loop that a validator moves to a round of the current height
+	wakeCh chan struct{}
+}
+
+func newRoundState() *roundState {
+	return &roundState{
+		changes: make(map[common.BlockNum]map[common.Address]uint64),
+		jumpCh:  make(chan uint64, 1),
+		wakeCh:  make(chan struct{}, 1),
+	}
+}
+
+// reset starts round 0 of height, or a higher round if enough validators have already moved to it.
+func (rs *roundState) reset(height common.BlockNum, weakQuorum int) {
+	rs.Lock()
+	defer rs.Unlock()
+	for hei := range rs.changes {
+		if hei < height {
+			delete(rs.changes, hei)
+		}
+	}
+	rs.height = height
+	rs.locked = 0
+	rs.round = agreedRound(rs.changes[height], weakQuorum)
+	select {
+	case <-rs.jumpCh:
+	default:
+	}
+}
+
+func (rs *roundState) current() uint64 {
+	rs.Lock()
+	defer rs.Unlock()
+	return rs.round
+}
+
+func (rs *roundState) moveTo(round uint64) bool {
+	rs.Lock()
+	defer rs.Unlock()
+	if round <= rs.round {
+		return false
+	}
+	rs.round = round
+	return true
+}
+
+// lock makes the blocks of the rounds below round unacceptable on height.
+func (rs *roundState) lock(height common.BlockNum, round uint64) {
+	rs.Lock()
+	defer rs.Unlock()
+	if height == rs.height && round > rs.locked {
+		rs.locked = round
+	}
+}
+
+// lockedRound returns the lowest round whose blocks are acceptable on the current height.
+func (rs *roundState) lockedRound() uint64 {
+	rs.Lock()
+	defer rs.Unlock()
+	return rs.locked
+}
+
+// record keeps the highest round voter has moved to on height.
+// It returns the round to jump to when weakQuorum validators have moved to a round higher than the local one.
+func (rs *roundState) record(height common.BlockNum, round uint64, voter common.Address, weakQuorum int) (uint64, bool) {
+	rs.Lock()
+	defer rs.Unlock()
+	if height < rs.height || height > rs.height+roundHeightsAhead {
+		return 0, false
+	}
+	// the local round of a higher height is 0 yet
+	if (height == rs.height && round > rs.round+roundsAhead) || (height > rs.height && round > roundsAhead) {
+		return 0, false
+	}
+	voters, ok := rs.changes[height]
+	if !ok {
+		voters = make(map[common.Address]uint64)
+		rs.changes[height] = voters
+	}
+	if highest, ok := voters[voter]; ok && round <= highest {
+		return 0, false
+	}
+	voters[voter] = round
+	if height != rs.height {
+		return 0, false
+	}
+	select {
+	case rs.wakeCh <- struct{}{}:
+	default:
+	}
+	agreed := agreedRound(voters, weakQuorum)
+	return agreed, agreed > rs.round
+}
+
+// supporters returns the number of validators who have moved to the round or a higher one.
+func (rs *roundState) supporters(height common.BlockNum, round uint64) int {
+	rs.Lock()
+	defer rs.Unlock()
+	count := 0
+	for _, highest := range rs.changes[height] {
+		if highest >= round {
+			count++
+		}
+	}
+	return count
+}
+
+// agreedRound returns the highest round weakQuorum validators have moved to, 0 if there is none.
+func agreedRound(voters map[common.Address]uint64, weakQuorum int) uint64 {
+	if weakQuorum <= 0 || len(voters) < weakQuorum {
+		return 0
+	}
+	rounds := make([]uint64, 0, len(voters))
+	for _, round := range voters {
+		rounds = append(rounds, round)
+	}
+	sort.Slice(rounds, func(i, j int) bool {
+		return rounds[i] > rounds[j]
+	})
+	return rounds[weakQuorum-1]
+}
+
+func (rs *roundState) notifyJump(round uint64) {
+	select {
+	case rs.jumpCh <- round:
+	default:
+		<-rs.jumpCh
+		rs.jumpCh <- round
+	}
+}
+
+// roundAgreed returns true if the validators have agreed to move to the round, so its leader can propose.
+// A backup leader never proposes alone on its own timeout, it needs weakQuorum validators,
+// itself included, to have moved to the round.
+func (h *Poa) roundAgreed(height common.BlockNum, round uint64) bool {
+	if round == 0 {
+		return true
+	}
+	return h.rounds.supporters(height, round) >= weakQuorum(h.ValidatorSetAt(height).Len())
+}
+
+// BlockRound returns the round the block is proposed in. Poa keeps it in the header nonce.
+func BlockRound(block *types.Block) uint64 {
+	return block.Nonce
+}
+
+// quorum for the validators to move to a higher round together.
+// More than 1/3 validators means at least one honest validator has timed out.
+func weakQuorum(n int) int {
+	return n/3 + 1
+}
+
+func (h *Poa) broadcastRoundChange(height common.BlockNum, round uint64) error {
+	rc := &RoundChange{
+		Height: height,
+		Round:  round,
+		Pubkey: h.myPubkey.BytesWithType(),
+	}
+	var err error
+	rc.Signature, err = h.signer.SignRoundChange(rc)
+	if err != nil {
+		return err
+	}
+	byt, err := rc.Encode()
+	if err != nil {
+		return err
+	}
+	return h.P2pNetwork.PubP2P(RoundChangeTopic, byt)
+}
+
+func (h *Poa) verifyRoundChange(rc *RoundChange) (common.Address, error) {
+	pubkey, err := keypair.PubKeyFromBytes(rc.Pubkey)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if pubkey == nil {
+		return common.Address{}, errors.New("round-change has no pubkey")
+	}
+	addr := pubkey.Address()
+	if !h.ValidatorSetAt(rc.Height).Contains(addr) {
+		return common.Address{}, errors.Errorf("round-change sender(%s) is not validator", addr.String())
+	}
+	if !pubkey.VerifySignature(rc.SignHash(), rc.Signature) {
+		return common.Address{}, errors.Errorf("round-change signature from %s is illegal", addr.String())
+	}
+	return addr, nil
+}
+
+func (h *Poa) handleRoundChanges() {
+	for {
+		msg, err := h.P2pNetwork.SubP2P(RoundChangeTopic)
+		if err != nil {
+			logrus.Error("subscribe round-change from P2P error: ", err)
+			continue
+		}
+		if h.failed.Load() {
+			continue
+		}
+		rc, err := DecodeRoundChange(msg)
+		if err != nil {
+			logrus.Error("decode round-change from p2p error: ", err)
+			continue
+		}
+		addr, err := h.verifyRoundChange(rc)
+		if err != nil {
+			logrus.Warn("verify round-change failed: ", err)
+			continue
+		}
+		if addr == h.LocalAddress() {
+			continue
+		}
+		logrus.Debugf("accept round-change height(%d) round(%d) from %s", rc.Height, rc.Round, addr.String())
+		h.observe(addr, rc.Height-1)
+
+		n := h.ValidatorSetAt(rc.Height).Len()
+		if jump, ok := h.rounds.record(rc.Height, rc.Round, addr, weakQuorum(n)); ok {
+			h.rounds.notifyJump(jump)
+		}
+	}
+}
+
+// useP2pOrSkip waits for the block of localBlock.Height from the leader of each round in turn.
+// It returns true if a valid block is received or synced and copied into localBlock,
+// or false once a round comes whose leader is the local node.
+func (h *Poa) useP2pOrSkip(localBlock *types.Block) bool {
+	height := localBlock.Height
+	var (
+		// the round whose timeout is counting down
+		timing   *uint64
+		deadline time.Time
+	)
+	for {
+		round := h.rounds.current()
+		if timing == nil || *timing != round {
+			timing = &round
+			deadline = h.clock.Now().Add(h.calculateWaitTime(localBlock))
+		}
+		if h.useReceived(localBlock) || h.catchUpIfBehind(localBlock) {
+			return true
+		}
+		leader, err := h.LeaderOf(height, localBlock.PrevHash, round)
+		if err != nil {
+			logrus.Errorf("elect leader of height(%d) round(%d) failed: %v", height, round, err)
+		}
+		if err == nil && leader == h.LocalAddress() && h.roundAgreed(height, round) {
+			localBlock.Nonce = round
+			return false
+		}
+
+		timer := h.clock.NewTimer(deadline.Sub(h.clock.Now()))
+		select {
+		case <-h.received.arriveCh:
+			timer.Stop()
+		case <-timer.C():
+			logrus.Infof("leader(%s) of height(%d) round(%d) timeout", leader.String(), height, round)
+			h.enterRound(height, round+1)
+		case <-h.syncer.behindCh:
+			timer.Stop()
+		case <-h.rounds.wakeCh:
+			timer.Stop()
+		case <-h.votes.addedCh:
+			timer.Stop()
+			h.tryFinalize()
+		case jump := <-h.rounds.jumpCh:
+			timer.Stop()
+			logrus.Infof("validators have moved to round(%d) on height(%d)", jump, height)
+			h.enterRound(height, jump)
+		}
+	}
+}
+
+func (h *Poa) enterRound(height common.BlockNum, round uint64) {
+	if !h.rounds.moveTo(round) {
+		return
+	}
+	if !h.IsValidator(h.LocalAddress()) {
+		return
+	}
+	h.rounds.record(height, round, h.LocalAddress(), weakQuorum(h.ValidatorSetAt(height).Len()))
+	// the round-change may reach others even if broadcasting fails
+	h.rounds.lock(height, round)
+	err := h.broadcastRoundChange(height, round)
+	if err != nil {
+		logrus.Error("broadcast round-change failed: ", err)
+	}
+}
@@ -0,0 +1,208 @@
+package poa
+
+import (
+	"encoding/json"
+	"github.com/cockroachdb/pebble"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/types"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var lastSignedKey = []byte("last_signed_block")
+
+// signState is the highest message signed by the validator.
+type signState struct {
+	Height common.BlockNum `json:"height"`
+	Round  uint64          `json:"round"`
+	// the hash of the block, or the sign hash of the vote and round-change
+	BlockHash common.Hash `json:"block_hash"`
+	Signature []byte      `json:"signature"`
+}
+
+// signStateStore keeps the signState durably, load returns nil if nothing is signed.
+type signStateStore interface {
+	load() (*signState, error)
+	save(state *signState) error
+	drop() error
+}
+
+// the kinds of messages a signGuard signs
+const (
+	signBlock       = "block"
+	signVote        = "vote"
+	signRoundChange = "round-change"
+)
+
+// signGuard never signs two messages of its kind on one height and round, nor one below the highest signed one.
+// The highest signed message is saved before its signature is returned, so the guard holds after a crash.
+type signGuard struct {
+	sync.Mutex
+	kind  string
+	store signStateStore
+	// nil if nothing is signed
+	state *signState
+}
+
+func newSignGuard(kind string, store signStateStore) (*signGuard, error) {
+	state, err := store.load()
+	if err != nil {
+		return nil, err
+	}
+	return &signGuard{kind: kind, store: store, state: state}, nil
+}
+
+// sign signs the block by signFn if it does not conflict with the highest signed one.
+// The same block as the highest one gets its signature again, in case the signer lost it.
+func (g *signGuard) sign(header *types.Header, signFn func(header *types.Header) ([]byte, error)) ([]byte, error) {
+	return g.signAt(header.Height, header.Nonce, HeaderHash(header), func() ([]byte, error) {
+		return signFn(header)
+	})
+}
+
+// signVote signs the vote if no other vote is signed on its height or above.
+// A vote has no round, a validator votes for one block on a height.
+func (g *signGuard) signVote(vote *Vote, signFn func(vote *Vote) ([]byte, error)) ([]byte, error) {
+	return g.signAt(vote.Height, 0, common.BytesToHash(vote.SignHash()), func() ([]byte, error) {
+		return signFn(vote)
+	})
+}
+
+// signRoundChange signs the round-change if it is above the highest signed one.
+func (g *signGuard) signRoundChange(rc *RoundChange, signFn func(rc *RoundChange) ([]byte, error)) ([]byte, error) {
+	return g.signAt(rc.Height, rc.Round, common.BytesToHash(rc.SignHash()), func() ([]byte, error) {
+		return signFn(rc)
+	})
+}
+
+// signAt signs the message of hash on height and round by signFn if it is above the highest signed one.
+// The same message as the highest one gets its signature again.
+func (g *signGuard) signAt(height common.BlockNum, round uint64, hash common.Hash, signFn func() ([]byte, error)) ([]byte, error) {
+	g.Lock()
+	defer g.Unlock()
+	if st := g.state; st != nil {
+		if height == st.Height && round == st.Round && hash == st.BlockHash {
+			return st.Signature, nil
+		}
+		if height < st.Height || (height == st.Height && round <= st.Round) {
+			return nil, SignRefused(g.kind, height, round, st.Height, st.Round)
+		}
+	}
+	sig, err := signFn()
+	if err != nil {
+		return nil, err
+	}
+	state := &signState{Height: height, Round: round, BlockHash: hash, Signature: sig}
+	err = g.store.save(state)
+	if err != nil {
+		return nil, err
+	}
+	g.state = state
+	return sig, nil
+}
+
+// override forgets the highest signed block if it is on height, so a different block can be signed on it.
+// It is the way out when the operator is sure the signed block was never published, for example the
+// validator crashed before broadcasting it. It returns false if the highest signed block is not on height.
+func (g *signGuard) override(height common.BlockNum) (bool, error) {
+	g.Lock()
+	defer g.Unlock()
+	if g.state == nil || g.state.Height != height {
+		return false, nil
+	}
+	err := g.store.drop()
+	if err != nil {
+		return false, err
+	}
+	logrus.Warnf("sign guard is overridden, forget the signed block(%s) of height(%d) round(%d)",
+		g.state.BlockHash.String(), g.state.Height, g.state.Round)
+	g.state = nil
+	return true, nil
+}
+
+// dbSignStore keeps the signState in the poa db.
+type dbSignStore struct {
+	db *pebble.DB
+}
+
+func (s *dbSignStore) load() (*signState, error) {
+	byt, closer, err := s.db.Get(lastSignedKey)
+	if errors.Is(err, pebble.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	state := new(signState)
+	err = json.Unmarshal(byt, state)
+	return state, err
+}
+
+func (s *dbSignStore) save(state *signState) error {
+	byt, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.db.Set(lastSignedKey, byt, pebble.Sync)
+}
+
+func (s *dbSignStore) drop() error {
+	return s.db.Delete(lastSignedKey, pebble.Sync)
+}
+
+// fileSignStore keeps the signState in a json file, used by the SignerServer which has no db.
+type fileSignStore struct {
+	path string
+}
+
+func (s *fileSignStore) load() (*signState, error) {
+	byt, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := new(signState)
+	err = json.Unmarshal(byt, state)
+	if err != nil {
+		return nil, errors.Wrapf(err, "sign state file(%s) is broken", s.path)
+	}
+	return state, nil
+}
+
+// save replaces the file by renaming, so a crash never leaves a half-written state.
+func (s *fileSignStore) save(state *signState) error {
+	byt, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(byt)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.path)
+}
+
+func (s *fileSignStore) drop() error {
+	err := os.Remove(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,41 @@
+package poa
+
+import (
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+)
+
+// Signer holds the key of the local validator and signs the blocks, votes and round-changes of Poa.
+type Signer interface {
+	PubKey() keypair.PubKey
+	// SignBlock signs the hash of the block header, the round is in the header nonce.
+	SignBlock(header *types.Header) ([]byte, error)
+	SignVote(vote *Vote) ([]byte, error)
+	SignRoundChange(rc *RoundChange) ([]byte, error)
+}
+
+// LocalSigner signs by the key in memory.
+type LocalSigner struct {
+	pubkey  keypair.PubKey
+	privkey keypair.PrivKey
+}
+
+func NewLocalSigner(pubkey keypair.PubKey, privkey keypair.PrivKey) *LocalSigner {
+	return &LocalSigner{pubkey: pubkey, privkey: privkey}
+}
+
+func (s *LocalSigner) PubKey() keypair.PubKey {
+	return s.pubkey
+}
+
+func (s *LocalSigner) SignBlock(header *types.Header) ([]byte, error) {
+	return s.privkey.SignData(HeaderHash(header).Bytes())
+}
+
+func (s *LocalSigner) SignVote(vote *Vote) ([]byte, error) {
+	return s.privkey.SignData(vote.SignHash())
+}
+
+func (s *LocalSigner) SignRoundChange(rc *RoundChange) ([]byte, error) {
+	return s.privkey.SignData(rc.SignHash())
+}
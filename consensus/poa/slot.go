@@ -0,0 +1,89 @@
+package poa
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/types"
+	"time"
+)
+
+// slotAnchor is a block the slots are counted from instead of the genesis.
+type slotAnchor struct {
+	height common.BlockNum
+	time   time.Time
+}
+
+// genesisSlot returns when the slot of height begins, the slots are BlockInterval apart from the genesis timestamp.
+// It is the same on all validators, so the blocks are verified against it.
+func (h *Poa) genesisSlot(height common.BlockNum) (time.Time, error) {
+	genesisTs, err := h.genesisTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	genesis := time.Unix(int64(genesisTs), 0)
+	return genesis.Add(time.Duration(height) * time.Duration(h.blockInterval) * time.Millisecond), nil
+}
+
+// slotStart returns when the slot of height begins locally, counted from the anchor after the chain stalls.
+func (h *Poa) slotStart(height common.BlockNum) (time.Time, error) {
+	anchor := h.anchor.Load()
+	if anchor == nil || height < anchor.height {
+		return h.genesisSlot(height)
+	}
+	return anchor.time.Add(time.Duration(height-anchor.height) * time.Duration(h.blockInterval) * time.Millisecond), nil
+}
+
+// genesisTimestamp returns GenesisTimestamp of the config, or the timestamp of the local genesis block if it is not set.
+func (h *Poa) genesisTimestamp() (uint64, error) {
+	if ts := h.genesisTs.Load(); ts > 0 {
+		return ts, nil
+	}
+	genesis, err := h.Chain.GetGenesis()
+	if err != nil {
+		return 0, errors.Wrap(err, "get genesis block")
+	}
+	h.genesisTs.Store(genesis.Timestamp)
+	return genesis.Timestamp, nil
+}
+
+// waitSlot waits until the slot of block begins, it returns at once if the slot has begun,
+// so a node behind the slots goes on without waiting until it catches up.
+// If the parent is stamped after the slot of block begins, the chain has stalled over the slot,
+// then the slots are counted from the parent, or all the slots passed in the stall would begin at once.
+func (h *Poa) waitSlot(block *types.Block) error {
+	slot, err := h.slotStart(block.Height)
+	if err != nil {
+		return err
+	}
+	parent, err := h.Chain.GetCompactBlock(block.PrevHash)
+	if err != nil {
+		logrus.Errorf("get parent(%s) of height(%d) failed: %v", block.PrevHash.String(), block.Height, err)
+	} else if parent.Height > 0 {
+		parentTime := time.Unix(int64(parent.Timestamp), 0)
+		if !parentTime.Before(slot) {
+			logrus.Infof("chain stalls over the slot of height(%d), the slots go on from the parent", block.Height)
+			h.anchor.Store(&slotAnchor{height: parent.Height, time: parentTime})
+			slot = parentTime.Add(time.Duration(h.blockInterval) * time.Millisecond)
+		}
+	}
+	h.clock.Sleep(slot.Sub(h.clock.Now()))
+	return nil
+}
+
+// observeLateness records how late the block is proposed or received after its slot begins.
+func (h *Poa) observeLateness(block *types.Block, source string) {
+	slot, err := h.slotStart(block.Height)
+	if err != nil {
+		logrus.Warnf("skip the lateness of block(%s): %v", block.Hash.String(), err)
+		return
+	}
+	late := h.clock.Since(slot)
+	if late < 0 {
+		late = 0
+	}
+	BlockLateness.WithLabelValues(source).Observe(late.Seconds())
+	if late > time.Duration(h.blockInterval)*time.Millisecond {
+		logrus.Debugf("block(%s) of height(%d) is %s late for its slot", block.Hash.String(), block.Height, late)
+	}
+}
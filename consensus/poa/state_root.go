@@ -0,0 +1,182 @@
+package poa
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/cockroachdb/pebble"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/common/yerror"
+	"github.com/yu-org/yu/core/context"
+	"github.com/yu-org/yu/core/types"
+	"net/http"
+	"time"
+)
+
+var stateRootMismatchPrefix = []byte("state_root_mismatch_")
+
+// StateRootMismatch is recorded when a validator executes the same block into different roots from the local node.
+type StateRootMismatch struct {
+	Height            common.BlockNum `json:"height"`
+	BlockHash         common.Hash     `json:"block_hash"`
+	Validator         common.Address  `json:"validator"`
+	LocalStateRoot    common.Hash     `json:"local_state_root"`
+	RemoteStateRoot   common.Hash     `json:"remote_state_root"`
+	LocalReceiptRoot  common.Hash     `json:"local_receipt_root"`
+	RemoteReceiptRoot common.Hash     `json:"remote_receipt_root"`
+}
+
+// receiptRoot is the merkle root of the receipts of block in the order of its txns.
+// The receipt root of yu is built over a map of receipts, so validators get different ones for a block of several txns.
+func (h *Poa) receiptRoot(block *types.Block) (common.Hash, error) {
+	hashes := make([]common.Hash, 0, len(block.Txns))
+	for _, txn := range block.Txns {
+		receipt, err := h.TxDB.GetReceipt(txn.TxnHash)
+		if err != nil {
+			return common.NullHash, err
+		}
+		// the txns after the one running out of lei are not executed
+		if receipt == nil || receipt.BlockHash != block.Hash {
+			continue
+		}
+		// the txns of a block from p2p carry no address, the caller is taken from the pubkey
+		receipt.Caller = nil
+		if caller, err := callerAddress(txn); err == nil {
+			receipt.Caller = &caller
+		}
+		hash, err := receipt.Hash()
+		if err != nil {
+			return common.NullHash, err
+		}
+		hashes = append(hashes, common.BytesToHash(hash))
+	}
+	return MerkleRoot(hashes), nil
+}
+
+// checkVotedRoots compares the roots in the vote with the local ones if the block has been executed locally.
+func (h *Poa) checkVotedRoots(voter common.Address, vote *Vote) {
+	local, err := h.Chain.GetCompactBlock(vote.BlockHash)
+	if errors.Is(err, yerror.ErrBlockNotFound) {
+		// not executed yet, it is checked in EndBlock.
+		return
+	}
+	if err != nil {
+		logrus.Errorf("get block(%s) for checking state root failed: %v", vote.BlockHash.String(), err)
+		return
+	}
+	h.checkStateRoot(local.Header, voter, vote)
+}
+
+// checkStateRoots compares the local block with the votes which arrive before its execution.
+func (h *Poa) checkStateRoots(block *types.Block) {
+	for voter, vote := range h.votes.votesOf(block.Height, block.Hash) {
+		h.checkStateRoot(block.Header, voter, vote)
+	}
+}
+
+func (h *Poa) checkStateRoot(local *types.Header, voter common.Address, vote *Vote) {
+	if vote.StateRoot == local.StateRoot && vote.ReceiptRoot == local.ReceiptRoot {
+		return
+	}
+	mismatch := &StateRootMismatch{
+		Height:            local.Height,
+		BlockHash:         local.Hash,
+		Validator:         voter,
+		LocalStateRoot:    local.StateRoot,
+		RemoteStateRoot:   vote.StateRoot,
+		LocalReceiptRoot:  local.ReceiptRoot,
+		RemoteReceiptRoot: vote.ReceiptRoot,
+	}
+	err := h.storeStateRootMismatch(mismatch)
+	if err != nil {
+		logrus.Error("store state root mismatch failed: ", err)
+	}
+	logrus.Errorf("state diverges on block(%s) height(%d) with validator(%s): "+
+		"state-root local(%s) remote(%s), receipt-root local(%s) remote(%s)",
+		local.Hash.String(), local.Height, voter.String(),
+		local.StateRoot.String(), vote.StateRoot.String(), local.ReceiptRoot.String(), vote.ReceiptRoot.String())
+
+	if !h.cfg.HaltOnStateDivergence {
+		return
+	}
+	if h.votes.divergence(local.Height, headerTarget(local)) >= quorum(h.ValidatorSetAt(local.Height).Len()) {
+		h.halt(local.Height)
+	}
+}
+
+// halt stops producing and voting blocks, because the local state is in the minority.
+func (h *Poa) halt(height common.BlockNum) {
+	if h.halted.Swap(true) {
+		return
+	}
+	logrus.Errorf("the local state of height(%d) is in the minority, poa halts!", height)
+}
+
+// Halted returns true if the node stops for its state diverges from the majority.
+func (h *Poa) Halted() bool {
+	return h.halted.Load()
+}
+
+func (h *Poa) waitIfHalted() {
+	for h.Halted() {
+		logrus.Warn("poa is halted for state divergence, check the state-root mismatches")
+		h.clock.Sleep(time.Duration(h.blockInterval) * time.Millisecond)
+	}
+}
+
+func stateRootMismatchKey(height common.BlockNum, validator common.Address) []byte {
+	key := make([]byte, len(stateRootMismatchPrefix)+8)
+	copy(key, stateRootMismatchPrefix)
+	binary.BigEndian.PutUint64(key[len(stateRootMismatchPrefix):], uint64(height))
+	return append(key, validator.Bytes()...)
+}
+
+func (h *Poa) storeStateRootMismatch(mismatch *StateRootMismatch) error {
+	byt, err := json.Marshal(mismatch)
+	if err != nil {
+		return err
+	}
+	return h.db.Set(stateRootMismatchKey(mismatch.Height, mismatch.Validator), byt, pebble.Sync)
+}
+
+// GetStateRootMismatches returns the state-root mismatches found from startHeight on, ascending by height.
+func (h *Poa) GetStateRootMismatches(startHeight common.BlockNum) ([]*StateRootMismatch, error) {
+	upper := make([]byte, len(stateRootMismatchPrefix))
+	copy(upper, stateRootMismatchPrefix)
+	upper[len(upper)-1]++
+	iter, err := h.db.NewIter(&pebble.IterOptions{
+		LowerBound: stateRootMismatchKey(startHeight, common.Address{}),
+		UpperBound: upper,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	mismatches := make([]*StateRootMismatch, 0)
+	for iter.First(); iter.Valid(); iter.Next() {
+		mismatch := new(StateRootMismatch)
+		err = json.Unmarshal(iter.Value(), mismatch)
+		if err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+	return mismatches, iter.Error()
+}
+
+func (h *Poa) QueryStateRootMismatches(ctx *context.ReadContext) {
+	var req HeightRequest
+	err := ctx.BindJson(&req)
+	if err != nil {
+		ctx.Err(http.StatusBadRequest, err)
+		return
+	}
+	mismatches, err := h.GetStateRootMismatches(req.Height)
+	if err != nil {
+		ctx.Err(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JsonOk(mismatches)
+}
@@ -0,0 +1,462 @@
+package poa
+
+import (
+	"encoding/json"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/tripod"
+	"github.com/yu-org/yu/core/types"
+	"sync"
+	"time"
+)
+
+const SyncBlocksCode = 110
+
+// the max number of blocks in one sync response
+const syncBatch = 64
+
+// the sync requests served per second and in a burst, the peers cannot keep the node busy reading its chain
+const (
+	syncRequestsPerSecond = 8
+	syncRequestBurst      = 16
+)
+
+type SyncRequest struct {
+	From common.BlockNum `json:"from"`
+	To   common.BlockNum `json:"to"`
+}
+
+type SyncResponse struct {
+	EndHeight common.BlockNum `json:"end_height"`
+	// encoded by types.EncodeBlocks, the finalized blocks come first
+	Blocks []byte `json:"blocks"`
+	// finality certificates of the blocks, nil if the block is not finalized yet
+	Certs []*FinalityCert `json:"certs"`
+	// the votes collected for the blocks not finalized yet, nil for the finalized ones
+	Votes [][]*Vote `json:"votes,omitempty"`
+}
+
+// syncedBlock is a finalized block from another validator, with its certificate.
+type syncedBlock struct {
+	block *types.Block
+	cert  *FinalityCert
+}
+
+// syncState tracks the heights the other validators have finished, and the synced blocks waiting to be replayed.
+type syncState struct {
+	sync.Mutex
+	// the highest height each validator has finished
+	finished map[common.Address]common.BlockNum
+	// the highest height finished by more than 1/3 validators
+	highestFinished common.BlockNum
+	// notify the waiting loop that the local node falls behind
+	behindCh chan struct{}
+
+	// the synced blocks in ascending height order
+	pending []*syncedBlock
+	// the certificate of the synced block in replay, it finalizes the block
+	replaying *FinalityCert
+}
+
+func newSyncState() *syncState {
+	return &syncState{
+		finished: make(map[common.Address]common.BlockNum),
+		behindCh: make(chan struct{}, 1),
+	}
+}
+
+// record marks the height finished by validator, and returns the highest height finished by more than 1/3 validators.
+// So at least one honest validator has finished it. raised is false if validator has finished the height already.
+func (s *syncState) record(validator common.Address, height common.BlockNum, validatorsAt func(common.BlockNum) *ValidatorSet) (highest common.BlockNum, raised bool) {
+	s.Lock()
+	defer s.Unlock()
+	if height <= s.finished[validator] {
+		return s.highestFinished, false
+	}
+	s.finished[validator] = height
+	for _, candidate := range s.finished {
+		if candidate <= s.highestFinished {
+			continue
+		}
+		set := validatorsAt(candidate)
+		supporters := 0
+		for addr, finished := range s.finished {
+			if finished >= candidate && set.Contains(addr) {
+				supporters++
+			}
+		}
+		if supporters >= weakQuorum(set.Len()) {
+			s.highestFinished = candidate
+		}
+	}
+	return s.highestFinished, true
+}
+
+func (s *syncState) highest() common.BlockNum {
+	s.Lock()
+	defer s.Unlock()
+	return s.highestFinished
+}
+
+// queue appends the synced blocks, they follow the pending ones.
+func (s *syncState) queue(blocks []*syncedBlock) {
+	s.Lock()
+	defer s.Unlock()
+	s.pending = append(s.pending, blocks...)
+}
+
+// take pops the synced block of height, the ones below it are dropped.
+// All pending blocks are dropped if there is none of height, they are on another chain.
+func (s *syncState) take(height common.BlockNum) *syncedBlock {
+	s.Lock()
+	defer s.Unlock()
+	for len(s.pending) > 0 && s.pending[0].block.Height < height {
+		s.pending = s.pending[1:]
+	}
+	if len(s.pending) == 0 || s.pending[0].block.Height != height {
+		s.pending = nil
+		return nil
+	}
+	synced := s.pending[0]
+	s.pending = s.pending[1:]
+	return synced
+}
+
+func (s *syncState) drop() {
+	s.Lock()
+	defer s.Unlock()
+	s.pending = nil
+}
+
+func (s *syncState) setReplaying(cert *FinalityCert) {
+	s.Lock()
+	defer s.Unlock()
+	s.replaying = cert
+}
+
+// replays returns true if block is the synced one in replay.
+func (s *syncState) replays(block *types.Block) bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.replaying != nil && s.replaying.BlockHash == block.Hash
+}
+
+// takeReplaying returns the certificate of block if it is a synced one.
+func (s *syncState) takeReplaying(block *types.Block) *FinalityCert {
+	s.Lock()
+	defer s.Unlock()
+	cert := s.replaying
+	if cert == nil || cert.Height != block.Height || cert.BlockHash != block.Hash {
+		return nil
+	}
+	s.replaying = nil
+	return cert
+}
+
+// observe records that validator has finished the height.
+func (h *Poa) observe(validator common.Address, finished common.BlockNum) {
+	highest, raised := h.syncer.record(validator, finished, h.ValidatorSetAt)
+	// the heights known already are not news, or the catch-up from them would wake itself up again
+	if raised && highest >= h.getCurrentHeight() {
+		select {
+		case h.syncer.behindCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (h *Poa) isBehind(height common.BlockNum) bool {
+	return h.syncer.highest() >= height
+}
+
+// catchUpIfBehind copies the next synced block into localBlock when the network has finished its height,
+// the block then goes through the lifecycle of all tripods, and is finalized by its certificate.
+// It returns true if localBlock is replaced.
+func (h *Poa) catchUpIfBehind(localBlock *types.Block) bool {
+	if !h.isBehind(localBlock.Height) {
+		return false
+	}
+	synced := h.syncer.take(localBlock.Height)
+	if synced == nil {
+		logrus.Debugf("local height(%d) falls behind the validators(%d), start to catch up",
+			localBlock.Height, h.syncer.highest())
+		err := h.fetchBlocks(localBlock.Height)
+		if err != nil {
+			logrus.Warn("catch up blocks failed: ", err)
+		}
+		synced = h.syncer.take(localBlock.Height)
+		if synced == nil {
+			return false
+		}
+	}
+
+	block := synced.block
+	err := h.verifySynced(localBlock, synced)
+	if err != nil {
+		logrus.Warnf("synced block(%s) of height(%d) verify failed: %v", block.Hash.String(), block.Height, err)
+		h.syncer.drop()
+		return false
+	}
+	logrus.Debugf("replay block(%s) height(%d)", block.Hash.String(), block.Height)
+
+	// the roots and lei are computed by the local execution
+	block.StateRoot = common.NullHash
+	block.ReceiptRoot = common.NullHash
+	block.LeiUsed = 0
+	localBlock.CopyFrom(block)
+	h.syncer.setReplaying(synced.cert)
+	h.State.StartBlock(localBlock)
+	return true
+}
+
+func (h *Poa) verifySynced(localBlock *types.Block, synced *syncedBlock) error {
+	block, cert := synced.block, synced.cert
+	if block.PrevHash != localBlock.PrevHash {
+		return errors.Errorf("parent(%s) is not the local chain end(%s)", block.PrevHash.String(), localBlock.PrevHash.String())
+	}
+	if cert.Height != block.Height || cert.BlockHash != block.Hash {
+		return errors.Errorf("finality certificate(%s) mismatches block(%s)", cert.BlockHash.String(), block.Hash.String())
+	}
+	err := cert.Verify(h.ValidatorSetAt(block.Height))
+	if err != nil {
+		return err
+	}
+	return h.RangeList(func(tri *tripod.Tripod) error {
+		return tri.BlockVerifier.VerifyBlock(block)
+	})
+}
+
+// fetchBlocks requests the blocks from height on from the validators, until one of them has some.
+// The finalized blocks are queued for replay, the others are buffered as p2p blocks,
+// which are verified and voted for like the ones from their leaders.
+func (h *Poa) fetchBlocks(height common.BlockNum) error {
+	var lastErr error
+	for _, peerID := range h.ValidatorsP2pID() {
+		if peerID == h.P2pNetwork.LocalID() {
+			continue
+		}
+		resp, err := h.requestBlocks(peerID, height, height+syncBatch-1)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		finalized, proposals, votes, err := decodeSynced(resp)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(finalized) > 0 && finalized[0].block.Height == height {
+			h.syncer.queue(finalized)
+		}
+		// the votes tell whether the proposals are built on by the validators, when the local node has left their rounds
+		for _, vote := range votes {
+			err = h.acceptVote(vote)
+			if err != nil {
+				logrus.Debug("drop synced vote: ", err)
+			}
+		}
+		for _, block := range proposals {
+			err = h.ReceiveBlock(block)
+			if err != nil {
+				logrus.Debugf("drop synced block(%s): %v", block.Hash.String(), err)
+			}
+		}
+		if len(finalized) > 0 || len(proposals) > 0 {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+func (h *Poa) requestBlocks(peerID peer.ID, from, to common.BlockNum) (*SyncResponse, error) {
+	req, err := json.Marshal(&SyncRequest{From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+	byt, err := h.P2pNetwork.RequestPeer(peerID, SyncBlocksCode, req)
+	if err != nil {
+		return nil, err
+	}
+	resp := new(SyncResponse)
+	err = json.Unmarshal(byt, resp)
+	return resp, err
+}
+
+// decodeSynced splits the blocks of the response into the finalized ones with their certificates, and the others
+// with the votes for them. They are verified when they are used.
+func decodeSynced(resp *SyncResponse) (finalized []*syncedBlock, proposals []*types.Block, votes []*Vote, err error) {
+	if len(resp.Blocks) == 0 {
+		return nil, nil, nil, nil
+	}
+	blocks, err := types.DecodeBlocks(resp.Blocks)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(resp.Certs) != len(blocks) {
+		return nil, nil, nil, errors.Errorf("sync response has %d blocks but %d certificates", len(blocks), len(resp.Certs))
+	}
+	if len(resp.Votes) > 0 && len(resp.Votes) != len(blocks) {
+		return nil, nil, nil, errors.Errorf("sync response has %d blocks but votes for %d", len(blocks), len(resp.Votes))
+	}
+	for i, block := range blocks {
+		if i > 0 && (block.Height != blocks[i-1].Height+1 || block.PrevHash != blocks[i-1].Hash) {
+			return nil, nil, nil, errors.Errorf("synced block(%s) does not follow block(%s)", block.Hash.String(), blocks[i-1].Hash.String())
+		}
+		if resp.Certs[i] == nil {
+			proposals = append(proposals, block)
+			if len(resp.Votes) > 0 {
+				for _, vote := range resp.Votes[i] {
+					if vote.Height == block.Height && vote.BlockHash == block.Hash {
+						votes = append(votes, vote)
+					}
+				}
+			}
+			continue
+		}
+		if len(proposals) > 0 {
+			return nil, nil, nil, errors.Errorf("synced block(%s) is finalized after an unfinalized one", block.Hash.String())
+		}
+		finalized = append(finalized, &syncedBlock{block: block, cert: resp.Certs[i]})
+	}
+	return finalized, proposals, votes, nil
+}
+
+func (h *Poa) handleSyncRequest(byt []byte) ([]byte, error) {
+	if h.failed.Load() {
+		return nil, errors.New("node has failed fatally")
+	}
+	if !h.syncServed.allow(h.clock.Now()) {
+		return nil, errors.New("too many sync requests")
+	}
+	req := new(SyncRequest)
+	err := json.Unmarshal(byt, req)
+	if err != nil {
+		return nil, err
+	}
+	end, err := h.Chain.GetEndCompactBlock()
+	if err != nil {
+		return nil, err
+	}
+	// the genesis block is not synced
+	if req.From == 0 {
+		req.From = 1
+	}
+	to := req.To
+	if to > end.Height {
+		to = end.Height
+	}
+	if to >= req.From+syncBatch {
+		to = req.From + syncBatch - 1
+	}
+
+	blocks := make([]*types.Block, 0)
+	certs := make([]*FinalityCert, 0)
+	votes := make([][]*Vote, 0)
+	for height := req.From; height <= to; height++ {
+		cert, err := h.GetFinalityCert(height)
+		if err != nil {
+			return nil, err
+		}
+		if cert == nil {
+			// the blocks not finalized yet may be on forks, only the ones on the local chain are sent
+			unfinalized, err := h.chainBetween(height, to)
+			if err != nil {
+				return nil, err
+			}
+			for _, block := range unfinalized {
+				blocks = append(blocks, block)
+				certs = append(certs, nil)
+				votes = append(votes, h.votesFor(block))
+			}
+			break
+		}
+		block, err := h.Chain.GetBlock(cert.BlockHash)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+		certs = append(certs, cert)
+		votes = append(votes, nil)
+	}
+
+	resp := &SyncResponse{EndHeight: end.Height, Certs: certs, Votes: votes}
+	if len(blocks) > 0 {
+		resp.Blocks, err = types.EncodeBlocks(blocks)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(resp)
+}
+
+// votesFor returns the votes collected for the block.
+func (h *Poa) votesFor(block *types.Block) []*Vote {
+	votes := make([]*Vote, 0)
+	for _, vote := range h.votes.votesOf(block.Height, block.Hash) {
+		votes = append(votes, vote)
+	}
+	return votes
+}
+
+// chainBetween returns the local blocks from height from to to, walking down from the block on height to.
+// A height with forks cannot tell its block on the local chain without walking from the chain end,
+// so the blocks from the top fork on are not returned.
+func (h *Poa) chainBetween(from, to common.BlockNum) ([]*types.Block, error) {
+	var hash common.Hash
+	for ; to >= from; to-- {
+		tops, err := h.Chain.GetAllCompactBlocksByHeight(to)
+		if err != nil {
+			return nil, err
+		}
+		if len(tops) == 1 {
+			hash = tops[0].Hash
+			break
+		}
+	}
+	blocks := make([]*types.Block, 0)
+	for height := to; height >= from; height-- {
+		block, err := h.Chain.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+		hash = block.PrevHash
+	}
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+	return blocks, nil
+}
+
+// syncLimiter is a token bucket of the sync requests served.
+type syncLimiter struct {
+	sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newSyncLimiter() *syncLimiter {
+	return &syncLimiter{tokens: syncRequestBurst}
+}
+
+// allow takes a token at now, it returns false if none is left.
+func (l *syncLimiter) allow(now time.Time) bool {
+	l.Lock()
+	defer l.Unlock()
+	if !l.last.IsZero() && now.After(l.last) {
+		l.tokens += now.Sub(l.last).Seconds() * syncRequestsPerSecond
+		if l.tokens > syncRequestBurst {
+			l.tokens = syncRequestBurst
+		}
+	}
+	if l.last.IsZero() || now.After(l.last) {
+		l.last = now
+	}
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
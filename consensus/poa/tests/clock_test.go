@@ -0,0 +1,38 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"testing"
+	"time"
+)
+
+func TestManualClock(t *testing.T) {
+	interval := 3 * time.Second
+	node := newTestNode(t, newMemNetwork(), 0, func(cfg *poa.PoaConfig) {
+		cfg.BlockInterval = int(interval.Milliseconds())
+		cfg.Validators = cfg.Validators[:1]
+	})
+	node.start(t)
+	clk := node.clock
+
+	// the only validator sleeps out each block interval on the clock
+	begin := time.Now()
+	for i := 0; i < 10; i++ {
+		clk.BlockUntil(1)
+		clk.Advance(interval)
+	}
+	waitHeight(t, []*testNode{node}, 10)
+	assert.Less(t, time.Since(begin), interval)
+
+	// blocks are stamped by the clock
+	for height := common.BlockNum(2); height <= 10; height++ {
+		parent, err := node.kernel.Chain.GetCompactBlock(mustBlockHash(t, node, height-1))
+		require.NoError(t, err)
+		block, err := node.kernel.Chain.GetCompactBlock(mustBlockHash(t, node, height))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(interval.Seconds()), block.Timestamp-parent.Timestamp, "height(%d)", height)
+	}
+}
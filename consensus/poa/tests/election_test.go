@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"testing"
+)
+
+func beaconMode(cfg *poa.PoaConfig) {
+	cfg.LeaderElection = poa.BeaconElection
+}
+
+func TestBeaconDistribution(t *testing.T) {
+	weights := []uint64{3, 1}
+	set := weightedSet(weights...)
+
+	counts := make(map[common.Address]int)
+	for i := 0; i < 4000; i++ {
+		seed := []byte(fmt.Sprintf("seed-%d", i))
+		leader := set.ProposerBySeed(seed)
+		assert.Equal(t, leader, set.ProposerBySeed(seed))
+		counts[leader]++
+	}
+	assert.InDelta(t, 3000, counts[set.Addrs[0]], 200)
+	assert.InDelta(t, 1000, counts[set.Addrs[1]], 200)
+}
+
+func TestBeaconVerifyBlock(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0, beaconMode)
+	node.kernel.InitBlockChain()
+	genesis, err := node.kernel.Chain.GetGenesis()
+	require.NoError(t, err)
+	maker := &blockMaker{t: t, node: node, genesis: genesis}
+
+	set := node.poa.ValidatorSetAt(1)
+	winner := set.ProposerBySeed(poa.Beacon(genesis.Header))
+	assert.Equal(t, winner, node.poa.CompeteLeader(1))
+
+	for _, secret := range poa.DefaultSecrets {
+		pub, _ := keypair.GenSrKeyWithSecret([]byte(secret))
+		err = node.poa.VerifyBlock(maker.make(secret, nil))
+		if pub.Address() == winner {
+			assert.NoError(t, err)
+			continue
+		}
+		// a validator who does not win the slot cannot propose in round 0
+		var notLeader poa.ErrMinerNotLeader
+		assert.ErrorAs(t, err, &notLeader)
+	}
+}
+
+func TestBeaconParentOnLocalChain(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0, beaconMode)
+	node.kernel.InitBlockChain()
+	genesis, err := node.kernel.Chain.GetGenesis()
+	require.NoError(t, err)
+	maker := &blockMaker{t: t, node: node, genesis: genesis}
+	set := node.poa.ValidatorSetAt(2)
+
+	// two blocks of height 1 stored locally, which elect different leaders of height 2
+	var side, local *types.Block
+	for i := 0; i < 64; i++ {
+		// sr25519 signatures are randomized, so are the beacons
+		side, local = maker.make(poa.DefaultSecrets[0], nil), maker.make(poa.DefaultSecrets[1], nil)
+		if set.ProposerBySeed(poa.Beacon(side.Header)) != set.ProposerBySeed(poa.Beacon(local.Header)) {
+			break
+		}
+	}
+	require.NotEqual(t, set.ProposerBySeed(poa.Beacon(side.Header)), set.ProposerBySeed(poa.Beacon(local.Header)))
+	require.NoError(t, node.kernel.Chain.AppendBlock(side))
+	require.NoError(t, node.kernel.Chain.AppendBlock(local))
+
+	// the leader is drawn from the chain end, not any block of the parent height
+	assert.Equal(t, set.ProposerBySeed(poa.Beacon(local.Header)), node.poa.CompeteLeader(2))
+}
+
+func TestBeaconElection(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, len(poa.DefaultSecrets), beaconMode)
+	startNodes(t, nodes)
+	waitHeight(t, nodes, 8)
+
+	chain := nodes[0].kernel.Chain
+	set := nodes[0].poa.ValidatorSetAt(1)
+	for height := common.BlockNum(1); height <= 8; height++ {
+		blocks, err := chain.GetAllCompactBlocksByHeight(height)
+		require.NoError(t, err)
+		require.Len(t, blocks, 1, "height(%d) forks", height)
+		for _, node := range nodes[1:] {
+			other, err := node.kernel.Chain.GetAllCompactBlocksByHeight(height)
+			require.NoError(t, err)
+			require.Len(t, other, 1, "height(%d) forks", height)
+			assert.Equal(t, blocks[0].Hash, other[0].Hash, "height(%d) forks", height)
+		}
+
+		// every block is proposed by the winner drawn from its parent signature
+		if blocks[0].Nonce != 0 {
+			continue
+		}
+		parent, err := chain.GetCompactBlock(blocks[0].PrevHash)
+		require.NoError(t, err)
+		miner, err := keypair.PubKeyFromBytes(blocks[0].MinerPubkey)
+		require.NoError(t, err)
+		assert.Equal(t, set.ProposerBySeed(poa.Beacon(parent.Header)), miner.Address(), "height(%d)", height)
+	}
+}
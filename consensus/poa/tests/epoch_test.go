@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/types"
+	"sync"
+	"testing"
+)
+
+func waitCheckpoint(t *testing.T, node *testNode, epoch uint64) *poa.SignedCheckpoint {
+	var cp *poa.SignedCheckpoint
+	node.network.runUntil(t, func() bool {
+		var err error
+		cp, err = node.poa.GetCheckpoint(epoch)
+		return err == nil && cp != nil
+	}, "checkpoint of epoch(%d) on node(%d)", epoch, node.idx)
+	return cp
+}
+
+func TestEpochCheckpoints(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, len(poa.DefaultSecrets), func(cfg *poa.PoaConfig) {
+		cfg.EpochLength = 4
+	})
+	var (
+		lock  sync.Mutex
+		ended = make(map[common.BlockNum]uint64)
+	)
+	nodes[0].poa.OnEpochEnd(func(block *types.Block, next *poa.Checkpoint) {
+		lock.Lock()
+		defer lock.Unlock()
+		ended[block.Height] = next.Epoch
+	})
+
+	// node3 leaves from epoch 1, the change in the middle of an epoch is refused
+	removed := poa.DefaultCfg(0).Validators[2].Pubkey
+	for _, secret := range poa.DefaultSecrets {
+		poolTxns(t, nodes, removeValidatorTxn(t, secret, 5, removed))
+	}
+	poolTxns(t, nodes, removeValidatorTxn(t, poa.DefaultSecrets[1], 6, poa.DefaultCfg(0).Validators[1].Pubkey))
+	startNodes(t, nodes)
+	requireAgree(t, nodes, 9)
+
+	genesisSet := nodes[0].poa.ValidatorSetAt(1)
+	trusted := genesisSet
+	for epoch := uint64(1); epoch <= 2; epoch++ {
+		for _, node := range nodes {
+			cp := waitCheckpoint(t, node, epoch)
+			assert.Equal(t, epoch, cp.Epoch)
+			assert.Equal(t, common.BlockNum(epoch*4), cp.Height)
+			assert.Equal(t, mustBlockHash(t, node, cp.Height), cp.BlockHash)
+			// each checkpoint is signed by the validators of the checkpoint before it
+			require.NoError(t, cp.Verify(trusted), "epoch(%d) on node(%d)", epoch, node.idx)
+		}
+		cp := waitCheckpoint(t, nodes[0], epoch)
+		next, err := cp.ValidatorSet()
+		require.NoError(t, err)
+		assert.Equal(t, nodes[0].poa.ValidatorSetAt(cp.Height+1).Addrs, next.Addrs)
+		trusted = next
+	}
+	assert.Equal(t, 2, trusted.Len())
+	assert.False(t, trusted.Contains(genesisSet.Addrs[2]))
+
+	lock.Lock()
+	assert.Equal(t, map[common.BlockNum]uint64{4: 1, 8: 2}, ended)
+	lock.Unlock()
+
+	// a checkpoint with another validator set loses its signatures
+	cp := waitCheckpoint(t, nodes[0], 1)
+	cp.Validators = cp.Validators[:1]
+	assert.Error(t, cp.Verify(genesisSet))
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"path/filepath"
+	"testing"
+)
+
+func signedHeader(t *testing.T, priv keypair.PrivKey, pub keypair.PubKey, height common.BlockNum, round uint64, txnRoot common.Hash) *types.Header {
+	peerID, err := peer.Decode("12D3KooWHHzSeKaY8xuZVzkLbKFfvNgPPeKhFBGrMbNzbm5akpqu")
+	require.NoError(t, err)
+	header := &types.Header{
+		Height:    height,
+		Nonce:     round,
+		TxnRoot:   txnRoot,
+		Timestamp: 1,
+		PeerID:    peerID,
+	}
+	header.Hash = poa.HeaderHash(header)
+	sig, err := priv.SignData(header.Hash.Bytes())
+	require.NoError(t, err)
+	header.MinerPubkey = pub.BytesWithType()
+	header.MinerSignature = sig
+	return header
+}
+
+func TestDoubleSignEvidence(t *testing.T) {
+	pub, priv := keypair.GenSrKeyWithSecret([]byte(poa.DefaultSecrets[0]))
+
+	a := signedHeader(t, priv, pub, 5, 0, common.HexToHash("0x01"))
+	b := signedHeader(t, priv, pub, 5, 0, common.HexToHash("0x02"))
+	evidence := &poa.DoubleSignEvidence{HeaderA: a, HeaderB: b}
+
+	offender, err := evidence.Verify()
+	require.NoError(t, err)
+	assert.Equal(t, pub.Address(), offender)
+
+	// evidence is portable
+	byt, err := evidence.Encode()
+	require.NoError(t, err)
+	decoded, err := poa.DecodeDoubleSignEvidence(byt)
+	require.NoError(t, err)
+	offender, err = decoded.Verify()
+	require.NoError(t, err)
+	assert.Equal(t, pub.Address(), offender)
+
+	cfg := poa.DefaultCfg(0)
+	cfg.DbPath = filepath.Join(t.TempDir(), "poa")
+	p := poa.NewPoa(cfg)
+	offender, err = p.VerifyEvidence(decoded)
+	require.NoError(t, err)
+	assert.Equal(t, pub.Address(), offender)
+
+	// same block
+	_, err = (&poa.DoubleSignEvidence{HeaderA: a, HeaderB: a}).Verify()
+	assert.Error(t, err)
+
+	// proposing again in another round is not double-sign
+	c := signedHeader(t, priv, pub, 5, 1, common.HexToHash("0x03"))
+	_, err = (&poa.DoubleSignEvidence{HeaderA: a, HeaderB: c}).Verify()
+	assert.Error(t, err)
+
+	// signed by another key
+	otherPub, otherPriv := keypair.GenSrKeyWithSecret([]byte("other"))
+	d := signedHeader(t, otherPriv, otherPub, 5, 0, common.HexToHash("0x04"))
+	_, err = (&poa.DoubleSignEvidence{HeaderA: a, HeaderB: d}).Verify()
+	assert.Error(t, err)
+
+	// the content does not match the signed hash
+	forged := *b
+	forged.Height = 6
+	_, err = (&poa.DoubleSignEvidence{HeaderA: a, HeaderB: &forged}).Verify()
+	assert.Error(t, err)
+
+	// not a validator
+	e := signedHeader(t, otherPriv, otherPub, 5, 0, common.HexToHash("0x05"))
+	_, err = p.VerifyEvidence(&poa.DoubleSignEvidence{HeaderA: d, HeaderB: e})
+	assert.Error(t, err)
+}
@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/types"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPublishRetry(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0, func(cfg *poa.PoaConfig) {
+		cfg.Validators = cfg.Validators[:1]
+	})
+	// round 0 of height 1 runs out of the retries and skips its slot,
+	// round 1 publishes its block at the third attempt.
+	node.p2p.failPub(common.StartBlockTopic, 5+2)
+	node.start(t)
+	waitHeight(t, []*testNode{node}, 2)
+
+	for height, round := range map[common.BlockNum]uint64{1: 1, 2: 0} {
+		block, err := node.kernel.Chain.GetCompactBlock(mustBlockHash(t, node, height))
+		require.NoError(t, err)
+		assert.Equal(t, round, poa.BlockRound(&types.Block{Header: block.Header}), "height(%d)", height)
+	}
+}
+
+func TestFatalFailure(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0, func(cfg *poa.PoaConfig) {
+		cfg.Validators = cfg.Validators[:1]
+	})
+	node.start(t)
+	waitHeight(t, []*testNode{node}, 2)
+
+	// sqlite refuses to write the chain db once it is moved
+	chainDB := filepath.Join(node.dir, "chain.db")
+	require.NoError(t, os.Rename(chainDB, chainDB+".moved"))
+
+	node.network.runUntil(t, node.poa.Failed, "node does not fail")
+	// the node stops its kernel by itself
+	select {
+	case <-node.shutdown:
+	case <-time.After(10 * time.Second):
+		t.Fatal("kernel does not stop")
+	}
+	err := node.fatal
+
+	var failed poa.ErrBlockFailed
+	require.ErrorAs(t, err, &failed)
+	assert.Equal(t, poa.FatalFailure, failed.Class)
+	assert.Equal(t, poa.AppendStage, failed.Stage)
+
+	failures, err := node.poa.GetBlockFailures()
+	require.NoError(t, err)
+	require.Len(t, failures, 1)
+	assert.Equal(t, failed.Height, failures[0].Height)
+	assert.Equal(t, poa.AppendStage, failures[0].Stage)
+	block, err := types.DecodeBlock(failures[0].Block)
+	require.NoError(t, err)
+	assert.Equal(t, failures[0].BlockHash, block.Hash)
+
+	// the failed block is not voted or finalized
+	assert.Less(t, node.height(), failed.Height)
+}
@@ -0,0 +1,804 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/nine-tripods/utils/clock"
+	"github.com/yu-org/yu/apps/synchronizer"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/config"
+	"github.com/yu-org/yu/core/blockchain"
+	"github.com/yu-org/yu/core/env"
+	"github.com/yu-org/yu/core/kernel"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/state"
+	"github.com/yu-org/yu/core/subscribe"
+	"github.com/yu-org/yu/core/tripod"
+	"github.com/yu-org/yu/core/tripod/dev"
+	"github.com/yu-org/yu/core/txdb"
+	"github.com/yu-org/yu/core/txpool"
+	"github.com/yu-org/yu/core/types"
+	"github.com/yu-org/yu/infra/storage/kv"
+	"github.com/yu-org/yu/utils/codec"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// nodeRoutines is the number of goroutines of a node using the network: the block loop of the kernel,
+// and the readers of StartBlockTopic, VoteTopic, RoundChangeTopic, EvidenceTopic and UnpackedTxnsTopic.
+const nodeRoutines = 6
+
+// the block interval of the nodes in milliseconds, their round timeout is the same.
+const blockInterval = 300
+
+const (
+	// how long the nodes may run on their clocks before runUntil gives up
+	runLimit = 2 * time.Minute
+	// how long the nodes may stay busy on one time, in the real world
+	settleTimeout = 10 * time.Second
+	// how many times in a row the nodes must be seen idle, and how often they are checked
+	settleChecks   = 3
+	settleInterval = time.Millisecond
+)
+
+func TestMain(m *testing.M) {
+	// the kernel encodes blocks and txns by the global codec
+	codec.GlobalCodec = &codec.RlpCodec{}
+	os.Exit(m.Run())
+}
+
+type testNode struct {
+	id     peer.ID
+	poa    *poa.Poa
+	kernel *kernel.Kernel
+	p2p    *memP2p
+	// the node is crashed or stopped
+	crashed bool
+	// the node is stopped when the test ends
+	stopOnCleanup bool
+
+	// kept to restart the node on the same storage
+	idx     int
+	opts    []func(cfg *poa.PoaConfig)
+	dir     string
+	kvdb    kv.Kvdb
+	network *memNetwork
+	// the clock of the node, moved by the network
+	clock *clock.Manual
+	// closed when the node stops its kernel on a fatal failure, which is kept in fatal
+	shutdown chan struct{}
+	fatal    error
+}
+
+func newTestNode(t *testing.T, network *memNetwork, idx int, opts ...func(cfg *poa.PoaConfig)) *testNode {
+	// background goroutines of the node may still write after the test ends,
+	// so the dir is removed without failing the test like t.TempDir.
+	dir, err := os.MkdirTemp("", "poa-node")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	// yu cannot close the kvdb, so a restarted node keeps using it.
+	kvdb, err := kv.NewKvdb(&config.KVconf{KvType: "pebble", Path: filepath.Join(dir, "yu.db")})
+	require.NoError(t, err)
+
+	n := &testNode{
+		idx:     idx,
+		opts:    opts,
+		dir:     dir,
+		kvdb:    kvdb,
+		network: network,
+	}
+	n.boot(t)
+	return n
+}
+
+// newTestNodes makes a node for each of the first num validators.
+func newTestNodes(t *testing.T, network *memNetwork, num int, opts ...func(cfg *poa.PoaConfig)) []*testNode {
+	nodes := make([]*testNode, 0, num)
+	for i := 0; i < num; i++ {
+		nodes = append(nodes, newTestNode(t, network, i, opts...))
+	}
+	return nodes
+}
+
+// validatorSecret is the secret of the validator of idx, the ones after the default validators are added by withValidators.
+func validatorSecret(idx int) string {
+	if idx < len(poa.DefaultSecrets) {
+		return poa.DefaultSecrets[idx]
+	}
+	return fmt.Sprintf("node%d", idx+1)
+}
+
+// withValidators adds validators after the default ones, up to num in all.
+func withValidators(num int) func(cfg *poa.PoaConfig) {
+	return func(cfg *poa.PoaConfig) {
+		for i := len(cfg.Validators); i < num; i++ {
+			pub, _ := keypair.GenSrKeyWithSecret([]byte(validatorSecret(i)))
+			// any distinct peer id does, it is taken from the ed25519 key of the same secret
+			edPub, _ := keypair.GenEdKeyWithSecret([]byte(validatorSecret(i)))
+			id, err := poa.PeerIDFromPubkey(edPub)
+			if err != nil {
+				panic(err)
+			}
+			cfg.Validators = append(cfg.Validators, &poa.ValidatorConf{Pubkey: pub.StringWithType(), P2pIp: id.String()})
+		}
+	}
+}
+
+func startNodes(t *testing.T, nodes []*testNode) {
+	for _, node := range nodes {
+		node.start(t)
+	}
+}
+
+// boot builds the tripods and kernel of the node on its storage.
+func (n *testNode) boot(t *testing.T) {
+	poaCfg := poa.DefaultCfg(min(n.idx, len(poa.DefaultSecrets)-1))
+	poaCfg.MySecret = validatorSecret(n.idx)
+	poaCfg.BlockInterval = blockInterval
+	poaCfg.PrettyLog = false
+	poaCfg.DbPath = filepath.Join(n.dir, "poa")
+	poaCfg.GenesisTimestamp = n.network.genesis
+	for _, opt := range n.opts {
+		opt(poaCfg)
+	}
+	poaTri := poa.NewPoa(poaCfg)
+	n.clock = clock.NewManual(n.network.now())
+	poaTri.WithClock(n.clock)
+
+	id, err := peer.Decode(poaCfg.Validators[n.idx].P2pIp)
+	require.NoError(t, err)
+
+	cfg := config.InitDefaultCfg()
+	cfg.DataDir = n.dir
+
+	p2p := n.network.join(id)
+	txnDB := txdb.NewTxDB(common.FullNode, n.kvdb)
+	chainEnv := &env.ChainEnv{
+		State: state.NewStateDB(cfg.StatedbType, n.kvdb),
+		Chain: blockchain.NewBlockChain(common.FullNode, &config.BlockchainConf{
+			ChainDB:   config.SqlDbConf{SqlDbType: "sqlite", Dsn: filepath.Join(n.dir, "chain.db")},
+			CacheSize: 10,
+		}, txnDB),
+		TxDB:       txnDB,
+		Pool:       txpool.WithDefaultChecks(common.FullNode, &cfg.Txpool),
+		Sub:        subscribe.NewSubscription(),
+		P2pNetwork: p2p,
+	}
+
+	instances := []any{poaTri, synchronizer.NewSynchronizer(synchronizer.FullSync)}
+	land := tripod.NewLand()
+	tripods := make([]*tripod.Tripod, 0)
+	for _, instance := range instances {
+		tri := tripod.ResolveTripod(instance)
+		tri.SetChainEnv(chainEnv)
+		tri.SetLand(land)
+		tri.SetInstance(instance)
+		tripods = append(tripods, tri)
+	}
+	land.SetTripods(tripods...)
+	for _, tri := range tripods {
+		chainEnv.Pool.WithTripodCheck(tri.Name(), tri.TxnChecker)
+	}
+	for _, instance := range instances {
+		require.NoError(t, tripod.Inject(instance))
+	}
+
+	n.id = id
+	n.poa = poaTri
+	n.p2p = p2p
+	n.kernel = kernel.NewKernel(cfg, chainEnv, land)
+	n.crashed = false
+	n.shutdown = make(chan struct{})
+}
+
+// start runs the node until it is stopped, or the test ends.
+func (n *testNode) start(t *testing.T) {
+	if !n.stopOnCleanup {
+		n.stopOnCleanup = true
+		t.Cleanup(func() {
+			n.stop(t)
+		})
+	}
+	// the node may boot before the network time moves
+	n.clock.Advance(n.network.now().Sub(n.clock.Now()))
+	n.kernel.InitBlockChain()
+	n.poa.StopOnFatal(n.kernel, func(err error) {
+		n.fatal = err
+		close(n.shutdown)
+	})
+	go n.kernel.Run()
+	n.network.run(n)
+}
+
+// stop shuts the node down, and releases its storage. A crashed node is left as it is.
+// The kernel is not stopped by Kernel.Stop, which waits forever for a node stuck in a height.
+func (n *testNode) stop(t *testing.T) {
+	if n.crashed {
+		return
+	}
+	select {
+	case <-n.shutdown:
+		// the kernel has stopped, the readers ignore the messages since the failure
+		require.NoError(t, n.poa.Close())
+		n.crashed = true
+		return
+	default:
+	}
+	n.halt()
+	// a node parked after a fatal failure never calls the network again,
+	// its storage is left to the removal of its dir.
+	if n.parked(2 * time.Second) {
+		require.NoError(t, n.poa.Close())
+	}
+	n.crashed = true
+}
+
+// crash kills the running node at once: it leaves the network, and every goroutine of it blocks
+// forever on its next network call, as if the process died there.
+// It returns when no goroutine of the node can touch the storage anymore.
+func (n *testNode) crash(t *testing.T) {
+	n.halt()
+	require.True(t, n.parked(10*time.Second), "node(%d) is still running", n.idx)
+	require.NoError(t, n.poa.Close())
+	n.crashed = true
+}
+
+func (n *testNode) halt() {
+	n.network.disconnect(n.id)
+	n.p2p.freeze()
+	// the requests from other nodes are served by their goroutines
+	n.p2p.serving.Wait()
+}
+
+// parked runs the halted node on its own clock until all of its goroutines are blocked,
+// false if they are not in timeout.
+func (n *testNode) parked(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for n.p2p.parked.Load() < nodeRoutines {
+		if time.Now().After(deadline) {
+			return false
+		}
+		// the block loop calls the network again when its timer fires
+		if next, ok := n.clock.Next(); ok {
+			n.clock.Advance(next.Sub(n.clock.Now()))
+		}
+		runtime.Gosched()
+	}
+	return true
+}
+
+// restart boots the crashed node again on what it stored, and runs it.
+func (n *testNode) restart(t *testing.T) {
+	require.True(t, n.crashed, "node(%d) is not crashed", n.idx)
+	n.boot(t)
+	n.start(t)
+}
+
+func (n *testNode) height() common.BlockNum {
+	block, err := n.kernel.Chain.GetEndCompactBlock()
+	if err != nil {
+		return 0
+	}
+	return block.Height
+}
+
+// idle tells if the node does nothing until its clock moves: its block loop waits on a timer,
+// or is parked after a fatal failure, and its network readers wait for messages.
+func (n *testNode) idle() bool {
+	return n.p2p.quiet() && (n.clock.Waiters() > 0 || n.poa.Failed())
+}
+
+func waitHeight(t *testing.T, nodes []*testNode, height common.BlockNum) {
+	nodes[0].network.runUntil(t, func() bool {
+		for _, node := range nodes {
+			if node.height() < height {
+				return false
+			}
+		}
+		return true
+	}, "wait for height(%d)", height)
+}
+
+// waitMined waits until the chain end of node is mined by itself. The node is not the next leader then,
+// so it is the moment to cut the node off without it proposing a block alone.
+func waitMined(t *testing.T, node *testNode, after common.BlockNum) {
+	node.network.runUntil(t, func() bool {
+		block, err := node.kernel.Chain.GetEndCompactBlock()
+		if err != nil || block.Height <= after {
+			return false
+		}
+		miner, err := keypair.PubKeyFromBytes(block.MinerPubkey)
+		return err == nil && miner.Address() == node.poa.LocalAddress()
+	}, "wait for node(%d) to mine after height(%d)", node.idx, after)
+}
+
+// requireAgree waits for the nodes to reach height, and requires they have the same single block on each height up to it.
+func requireAgree(t *testing.T, nodes []*testNode, height common.BlockNum) {
+	waitHeight(t, nodes, height)
+	for h := common.BlockNum(1); h <= height; h++ {
+		hash := mustBlockHash(t, nodes[0], h)
+		for _, node := range nodes {
+			blocks, err := node.kernel.Chain.GetAllCompactBlocksByHeight(h)
+			require.NoError(t, err)
+			require.Len(t, blocks, 1, "height(%d) forks on node(%d)", h, node.idx)
+			assert.Equal(t, hash, blocks[0].Hash, "height(%d) forks on node(%d)", h, node.idx)
+		}
+	}
+}
+
+func mustBlockHash(t *testing.T, node *testNode, height common.BlockNum) common.Hash {
+	blocks, err := node.kernel.Chain.GetAllCompactBlocksByHeight(height)
+	require.NoError(t, err)
+	require.NotEmpty(t, blocks)
+	return blocks[0].Hash
+}
+
+// poolTxns puts the txns into the pools of all nodes, whichever leader packs them.
+func poolTxns(t *testing.T, nodes []*testNode, txns ...*types.SignedTxn) {
+	for _, node := range nodes {
+		for _, txn := range txns {
+			require.NoError(t, node.kernel.Pool.Insert(txn))
+		}
+	}
+}
+
+// linkRule decides how a message published on topic travels from one node to another,
+// it is delayed by delay, or dropped.
+type linkRule func(from, to peer.ID, topic string) (delay time.Duration, drop bool)
+
+// memNetwork connects the nodes in one process, it delivers every published message to all reachable nodes.
+// The nodes run on manual clocks, which only runUntil moves, all together.
+type memNetwork struct {
+	sync.RWMutex
+	nodes map[peer.ID]*memP2p
+	// the group of each node while the network is partitioned, nil if not
+	groups map[peer.ID]int
+	rule   linkRule
+	// the genesis timestamp of the nodes, their slots are aligned
+	genesis uint64
+
+	// the time of the nodes
+	time time.Time
+	// the started nodes, until they halt
+	running map[peer.ID]*testNode
+
+	delayLock sync.Mutex
+	// the messages delayed by the rule, in the order they are published
+	delayed []*delayedMsg
+}
+
+// delayedMsg is delivered when the time of the network reaches due.
+type delayedMsg struct {
+	due   time.Time
+	from  peer.ID
+	to    *memP2p
+	topic string
+	msg   []byte
+}
+
+func newMemNetwork() *memNetwork {
+	start := time.Unix(time.Now().Unix(), 0)
+	return &memNetwork{
+		nodes:   make(map[peer.ID]*memP2p),
+		genesis: uint64(start.Unix()),
+		time:    start,
+		running: make(map[peer.ID]*testNode),
+	}
+}
+
+func (n *memNetwork) now() time.Time {
+	n.RLock()
+	defer n.RUnlock()
+	return n.time
+}
+
+func (n *memNetwork) run(node *testNode) {
+	n.Lock()
+	defer n.Unlock()
+	n.running[node.id] = node
+}
+
+func (n *memNetwork) runningNodes() []*testNode {
+	n.RLock()
+	defer n.RUnlock()
+	nodes := make([]*testNode, 0, len(n.running))
+	for _, node := range n.running {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// runUntil runs the nodes until done returns true. Each step lets the nodes finish what they do at the current time,
+// then moves their clocks to the earliest timer of them, or delivers the earliest delayed message.
+func (n *memNetwork) runUntil(t *testing.T, done func() bool, msgAndArgs ...any) {
+	t.Helper()
+	limit := n.now().Add(runLimit)
+	for {
+		n.settle(t)
+		if done() {
+			return
+		}
+		next, ok := n.next()
+		if !ok || next.After(limit) {
+			require.FailNow(t, "the nodes stop before done", msgAndArgs...)
+		}
+		n.advance(next)
+	}
+}
+
+// runFor runs the nodes for d on their clocks.
+func (n *memNetwork) runFor(t *testing.T, d time.Duration) {
+	t.Helper()
+	end := n.now().Add(d)
+	n.runUntil(t, func() bool {
+		return !n.now().Before(end)
+	})
+}
+
+// settle waits until the running nodes are all idle. They must be seen idle a few times in a row,
+// as a block loop woken by a message may not have stopped its timer yet.
+func (n *memNetwork) settle(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(settleTimeout)
+	for idle := 0; idle < settleChecks; {
+		require.False(t, time.Now().After(deadline), "the nodes are still busy after %s", settleTimeout)
+		idle++
+		for _, node := range n.runningNodes() {
+			if !node.idle() {
+				idle = 0
+				break
+			}
+		}
+		time.Sleep(settleInterval)
+	}
+}
+
+// next returns the time of the earliest timer of the running nodes, or of the earliest delayed message.
+func (n *memNetwork) next() (time.Time, bool) {
+	var (
+		next  time.Time
+		found bool
+	)
+	earlier := func(at time.Time) {
+		if !found || at.Before(next) {
+			next, found = at, true
+		}
+	}
+	for _, node := range n.runningNodes() {
+		if at, ok := node.clock.Next(); ok {
+			earlier(at)
+		}
+	}
+	n.delayLock.Lock()
+	for _, msg := range n.delayed {
+		earlier(msg.due)
+	}
+	n.delayLock.Unlock()
+	return next, found
+}
+
+// advance moves the time of the network and the clocks of the running nodes to to,
+// the delayed messages due by then are delivered first.
+func (n *memNetwork) advance(to time.Time) {
+	n.Lock()
+	n.time = to
+	n.Unlock()
+
+	n.delayLock.Lock()
+	due := make([]*delayedMsg, 0)
+	kept := n.delayed[:0]
+	for _, msg := range n.delayed {
+		if msg.due.After(to) {
+			kept = append(kept, msg)
+		} else {
+			due = append(due, msg)
+		}
+	}
+	n.delayed = kept
+	n.delayLock.Unlock()
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].due.Before(due[j].due)
+	})
+	for _, msg := range due {
+		n.deliver(msg.from, msg.to, msg.topic, msg.msg)
+	}
+
+	for _, node := range n.runningNodes() {
+		node.clock.Advance(to.Sub(node.clock.Now()))
+	}
+}
+
+// delay must be called with the lock held.
+func (n *memNetwork) delay(from peer.ID, to *memP2p, topic string, msg []byte, d time.Duration) {
+	n.delayLock.Lock()
+	defer n.delayLock.Unlock()
+	n.delayed = append(n.delayed, &delayedMsg{
+		due:   n.time.Add(d),
+		from:  from,
+		to:    to,
+		topic: topic,
+		msg:   msg,
+	})
+}
+
+func (n *memNetwork) join(id peer.ID) *memP2p {
+	n.Lock()
+	defer n.Unlock()
+	p := &memP2p{
+		id:          id,
+		network:     n,
+		topics:      make(map[string]chan []byte),
+		frozen:      make(chan struct{}),
+		pubFailures: make(map[string]int),
+	}
+	n.nodes[id] = p
+	return p
+}
+
+func (n *memNetwork) disconnect(id peer.ID) {
+	n.Lock()
+	defer n.Unlock()
+	delete(n.nodes, id)
+	delete(n.running, id)
+}
+
+// partition splits the network into groups, nodes reach only the ones in their group.
+// Nodes out of all groups reach nobody.
+func (n *memNetwork) partition(groups ...[]*testNode) {
+	n.Lock()
+	defer n.Unlock()
+	n.groups = make(map[peer.ID]int)
+	for i, group := range groups {
+		for _, node := range group {
+			n.groups[node.id] = i
+		}
+	}
+}
+
+// heal ends the partition.
+func (n *memNetwork) heal() {
+	n.Lock()
+	defer n.Unlock()
+	n.groups = nil
+}
+
+// setRule applies rule to the messages published from now on, nil removes it.
+// The messages a node publishes to itself are not on any link, the rule never applies to them.
+func (n *memNetwork) setRule(rule linkRule) {
+	n.Lock()
+	defer n.Unlock()
+	n.rule = rule
+}
+
+// reachable must be called with the lock held.
+func (n *memNetwork) reachable(from, to peer.ID) bool {
+	if _, ok := n.nodes[from]; !ok {
+		return false
+	}
+	if _, ok := n.nodes[to]; !ok {
+		return false
+	}
+	if n.groups == nil || from == to {
+		return true
+	}
+	fromGroup, ok := n.groups[from]
+	if !ok {
+		return false
+	}
+	toGroup, ok := n.groups[to]
+	return ok && fromGroup == toGroup
+}
+
+// deliver drops the message if the node cannot be reached from the publisher now, or its topic is full.
+func (n *memNetwork) deliver(from peer.ID, to *memP2p, topic string, msg []byte) {
+	n.RLock()
+	defer n.RUnlock()
+	if n.nodes[to.id] != to || !n.reachable(from, to.id) {
+		return
+	}
+	to.push(topic, msg)
+}
+
+type memP2p struct {
+	sync.Mutex
+	id       peer.ID
+	network  *memNetwork
+	topics   map[string]chan []byte
+	handlers map[int]dev.P2pHandler
+
+	// closed when the node crashes
+	frozen chan struct{}
+	// the goroutines of the node blocked after it crashed
+	parked atomic.Int32
+	// the goroutines of the node waiting for messages
+	waiting atomic.Int32
+	// the messages pushed to the node and not taken by its readers yet, a message handed to a waiting reader
+	// is counted until the reader wakes up, as it leaves no trace in the channel
+	undelivered atomic.Int32
+	// the requests of other nodes in handling
+	serving sync.WaitGroup
+	// the number of the next publishes failing on each topic
+	pubFailures map[string]int
+}
+
+func (p *memP2p) topic(name string) chan []byte {
+	p.Lock()
+	defer p.Unlock()
+	ch, ok := p.topics[name]
+	if !ok {
+		ch = make(chan []byte, 1024)
+		p.topics[name] = ch
+	}
+	return ch
+}
+
+func (p *memP2p) freeze() {
+	close(p.frozen)
+}
+
+func (p *memP2p) isFrozen() bool {
+	select {
+	case <-p.frozen:
+		return true
+	default:
+		return false
+	}
+}
+
+// park blocks the calling goroutine of a crashed node forever.
+func (p *memP2p) park(counted bool) {
+	if counted {
+		p.parked.Add(1)
+	}
+	select {}
+}
+
+func (p *memP2p) LocalID() peer.ID {
+	return p.id
+}
+
+func (p *memP2p) LocalIdString() string {
+	return p.id.String()
+}
+
+func (p *memP2p) GetBootNodes() []peer.ID {
+	return nil
+}
+
+func (p *memP2p) ConnectBootNodes() error {
+	return nil
+}
+
+func (p *memP2p) AddTopic(topicName string) {
+	p.topic(topicName)
+}
+
+func (p *memP2p) SetHandlers(handlers map[int]dev.P2pHandler) {
+	p.handlers = handlers
+}
+
+func (p *memP2p) RequestPeer(peerID peer.ID, code int, request []byte) ([]byte, error) {
+	if p.isFrozen() {
+		p.park(true)
+	}
+	p.network.RLock()
+	target, ok := p.network.nodes[peerID]
+	if !ok || !p.network.reachable(p.id, peerID) {
+		p.network.RUnlock()
+		return nil, errPeerUnreachable
+	}
+	handler, ok := target.handlers[code]
+	if !ok {
+		p.network.RUnlock()
+		return nil, errPeerUnreachable
+	}
+	target.serving.Add(1)
+	p.network.RUnlock()
+	defer target.serving.Done()
+	return handler(request)
+}
+
+// failPub makes the next n publishes on topic fail.
+func (p *memP2p) failPub(topic string, n int) {
+	p.Lock()
+	defer p.Unlock()
+	p.pubFailures[topic] = n
+}
+
+func (p *memP2p) pubFails(topic string) bool {
+	p.Lock()
+	defer p.Unlock()
+	if p.pubFailures[topic] == 0 {
+		return false
+	}
+	p.pubFailures[topic]--
+	return true
+}
+
+func (p *memP2p) PubP2P(topic string, msg []byte) error {
+	if p.isFrozen() {
+		// the kernel publishes the txns of clients in goroutines of their own, which touch no storage
+		p.park(topic != common.UnpackedTxnsTopic)
+	}
+	if p.pubFails(topic) {
+		return errPubFailed
+	}
+	p.network.RLock()
+	defer p.network.RUnlock()
+	if _, ok := p.network.nodes[p.id]; !ok {
+		return nil
+	}
+	for _, node := range p.network.nodes {
+		if !p.network.reachable(p.id, node.id) {
+			continue
+		}
+		if node.id == p.id || p.network.rule == nil {
+			node.push(topic, msg)
+			continue
+		}
+		delay, drop := p.network.rule(p.id, node.id, topic)
+		switch {
+		case drop:
+		case delay > 0:
+			p.network.delay(p.id, node, topic, msg, delay)
+		default:
+			node.push(topic, msg)
+		}
+	}
+	return nil
+}
+
+// push drops the message if the topic is full.
+func (p *memP2p) push(topic string, msg []byte) {
+	p.undelivered.Add(1)
+	select {
+	case p.topic(topic) <- msg:
+	default:
+		p.undelivered.Add(-1)
+	}
+}
+
+func (p *memP2p) SubP2P(topic string) ([]byte, error) {
+	ch := p.topic(topic)
+	p.waiting.Add(1)
+	select {
+	case msg := <-ch:
+		p.waiting.Add(-1)
+		p.undelivered.Add(-1)
+		if !p.isFrozen() {
+			return msg, nil
+		}
+	case <-p.frozen:
+		p.waiting.Add(-1)
+	}
+	p.park(true)
+	return nil, nil
+}
+
+// quiet tells if all readers of the node wait for messages, and no message is left to them.
+func (p *memP2p) quiet() bool {
+	return p.waiting.Load() >= nodeRoutines-1 && p.undelivered.Load() == 0
+}
+
+type netError string
+
+func (e netError) Error() string {
+	return string(e)
+}
+
+const (
+	errPeerUnreachable = netError("peer unreachable")
+	errPubFailed       = netError("publish failed")
+)
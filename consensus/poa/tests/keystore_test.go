@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/core/keypair"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeystore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "validator.json")
+	ks, err := poa.NewKeystore(keypair.Sr25519, []byte("validator-secret"), []byte("passphrase"))
+	require.NoError(t, err)
+	require.NoError(t, ks.Save(path))
+	// never overwrite a keystore
+	assert.Error(t, ks.Save(path))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	loaded, err := poa.LoadKeystore(path)
+	require.NoError(t, err)
+	pub, priv, err := loaded.KeyPair([]byte("passphrase"))
+	require.NoError(t, err)
+	expectPub, expectPriv := keypair.GenSrKeyWithSecret([]byte("validator-secret"))
+	assert.Equal(t, expectPub.StringWithType(), pub.StringWithType())
+	assert.Equal(t, expectPriv.StringWithType(), priv.StringWithType())
+
+	_, _, err = loaded.KeyPair([]byte("wrong"))
+	assert.ErrorIs(t, err, poa.ErrKeystorePassphrase)
+
+	generated, err := poa.GenKeystore(keypair.Ed25519, []byte("passphrase"))
+	require.NoError(t, err)
+	secret, err := generated.Decrypt([]byte("passphrase"))
+	require.NoError(t, err)
+	assert.False(t, poa.IsDefaultSecret(secret))
+}
+
+func TestLoadKeyPairFromKeystore(t *testing.T) {
+	dir := t.TempDir()
+	ks, err := poa.NewKeystore(keypair.Sr25519, []byte("validator-secret"), []byte("passphrase"))
+	require.NoError(t, err)
+	keystorePath := filepath.Join(dir, "validator.json")
+	require.NoError(t, ks.Save(keystorePath))
+
+	cfg := poa.DefaultCfg(0)
+	cfg.MySecret = ""
+	cfg.Keystore = keystorePath
+
+	t.Setenv(poa.DefaultPassphraseEnv, "passphrase")
+	pub, _, err := poa.LoadKeyPair(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, ks.Pubkey, pub.StringWithType())
+
+	// the passphrase file is used first
+	passphraseFile := filepath.Join(dir, "passphrase")
+	require.NoError(t, os.WriteFile(passphraseFile, []byte("wrong\n"), 0600))
+	cfg.PassphraseFile = passphraseFile
+	_, _, err = poa.LoadKeyPair(cfg)
+	assert.ErrorIs(t, err, poa.ErrKeystorePassphrase)
+	cfg.PassphraseFile = ""
+
+	cfg.MySecret = "validator-secret"
+	_, _, err = poa.LoadKeyPair(cfg)
+	assert.Error(t, err, "my_secret and keystore are both set")
+}
+
+func TestProductionRefusesDefaultSecrets(t *testing.T) {
+	cfg := poa.DefaultCfg(0)
+	cfg.Production = true
+	_, _, err := poa.LoadKeyPair(cfg)
+	assert.Error(t, err)
+
+	// the local key is new but the validators are still the default ones
+	cfg.MySecret = "validator-secret"
+	_, _, err = poa.LoadKeyPair(cfg)
+	assert.Error(t, err)
+
+	for i := range cfg.Validators {
+		pub, _ := keypair.GenSrKeyWithSecret([]byte(cfg.MySecret + string(rune('a'+i))))
+		cfg.Validators[i].Pubkey = pub.StringWithType()
+	}
+	_, _, err = poa.LoadKeyPair(cfg)
+	assert.NoError(t, err)
+
+	// the default secret in a keystore is refused as well
+	ks, err := poa.NewKeystore(keypair.Sr25519, []byte(poa.DefaultSecrets[0]), []byte("passphrase"))
+	require.NoError(t, err)
+	cfg.MySecret = ""
+	cfg.Keystore = filepath.Join(t.TempDir(), "validator.json")
+	require.NoError(t, ks.Save(cfg.Keystore))
+	t.Setenv(poa.DefaultPassphraseEnv, "passphrase")
+	_, _, err = poa.LoadKeyPair(cfg)
+	assert.Error(t, err)
+
+	// the default validators are refused before dialing the remote signer
+	remote := poa.DefaultCfg(0)
+	remote.Production = true
+	remote.MySecret = ""
+	remote.RemoteSigner = filepath.Join(t.TempDir(), "signer.sock")
+	_, err = poa.LoadSigner(remote)
+	assert.ErrorContains(t, err, "default secret")
+}
@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/nine-tripods/consensus/poa/lightclient"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/common/yerror"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"testing"
+)
+
+func headerAt(t *testing.T, node *testNode, height common.BlockNum) *types.Header {
+	block, err := node.kernel.Chain.GetCompactBlock(mustBlockHash(t, node, height))
+	require.NoError(t, err)
+	return block.Header
+}
+
+func waitFinalityCert(t *testing.T, node *testNode, height common.BlockNum) *poa.FinalityCert {
+	var cert *poa.FinalityCert
+	node.network.runUntil(t, func() bool {
+		var err error
+		cert, err = node.poa.GetFinalityCert(height)
+		return err == nil && cert != nil
+	}, "finality certificate of height(%d)", height)
+	return cert
+}
+
+func TestLightClient(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, len(poa.DefaultSecrets), func(cfg *poa.PoaConfig) {
+		cfg.EpochLength = 4
+	})
+	// node3 leaves from epoch 1
+	for _, secret := range poa.DefaultSecrets {
+		poolTxns(t, nodes, removeValidatorTxn(t, secret, 5, poa.DefaultCfg(0).Validators[2].Pubkey))
+	}
+	startNodes(t, nodes)
+	requireAgree(t, nodes, 10)
+	full := nodes[0]
+
+	infos, err := poa.ResolveValidators(poa.DefaultCfg(0))
+	require.NoError(t, err)
+	genesis, err := full.kernel.Chain.GetGenesis()
+	require.NoError(t, err)
+	cfg := lightclient.Config{EpochLength: 4}
+	client := lightclient.New(cfg, genesis.Header, poa.NewValidatorSet(1, infos))
+
+	// forged headers of height 1
+	header := headerAt(t, full, 1)
+	cert := waitFinalityCert(t, full, 1)
+
+	forged := *header
+	forged.Timestamp++
+	var hashMismatch poa.ErrBlockHashMismatch
+	assert.ErrorAs(t, client.Verify(&forged, cert), &hashMismatch)
+
+	forged = *header
+	forged.MinerSignature = append([]byte{}, header.MinerSignature...)
+	forged.MinerSignature[0]++
+	var sigIllegal yerror.ErrBlockSignatureIllegal
+	assert.ErrorAs(t, client.Verify(&forged, cert), &sigIllegal)
+
+	// node2 is not the leader of height 1
+	pub, priv := keypair.GenSrKeyWithSecret([]byte(poa.DefaultSecrets[1]))
+	forged = *header
+	forged.MinerPubkey = pub.BytesWithType()
+	forged.Hash = poa.HeaderHash(&forged)
+	forged.MinerSignature, err = priv.SignData(forged.Hash.Bytes())
+	require.NoError(t, err)
+	var notLeader poa.ErrMinerNotLeader
+	assert.ErrorAs(t, client.Verify(&forged, cert), &notLeader)
+
+	var certMismatch lightclient.ErrCertMismatch
+	assert.ErrorAs(t, client.Verify(header, waitFinalityCert(t, full, 2)), &certMismatch)
+
+	// the headers are followed across the epochs by the checkpoints
+	for height := common.BlockNum(1); height <= 9; height++ {
+		if height == 5 || height == 9 {
+			var required lightclient.ErrCheckpointRequired
+			assert.ErrorAs(t, client.Verify(headerAt(t, full, height), waitFinalityCert(t, full, height)), &required)
+			require.NoError(t, client.ApplyCheckpoint(waitCheckpoint(t, full, client.Epoch()+1)))
+		}
+		require.NoError(t, client.Verify(headerAt(t, full, height), waitFinalityCert(t, full, height)), "height(%d)", height)
+	}
+	assert.Equal(t, uint64(2), client.Epoch())
+	assert.Equal(t, 2, client.Validators().Len())
+	assert.Equal(t, full.poa.ValidatorSetAt(9).Addrs, client.Validators().Addrs)
+
+	// a client can start from a checkpoint as well
+	cp := waitCheckpoint(t, full, 1)
+	client, err = lightclient.NewFromCheckpoint(cfg, headerAt(t, full, 4), cp.Checkpoint)
+	require.NoError(t, err)
+	for height := common.BlockNum(5); height <= 8; height++ {
+		require.NoError(t, client.Verify(headerAt(t, full, height), waitFinalityCert(t, full, height)), "height(%d)", height)
+	}
+	_, err = lightclient.NewFromCheckpoint(cfg, headerAt(t, full, 3), cp.Checkpoint)
+	var cpMismatch lightclient.ErrCheckpointMismatch
+	assert.ErrorAs(t, err, &cpMismatch)
+}
+
+func TestTxnProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		txns := make([]*types.SignedTxn, 0)
+		hashes := make([]common.Hash, 0)
+		for i := 0; i < n; i++ {
+			hash := common.BytesToHash(common.Sha256([]byte{byte(n), byte(i)}))
+			txns = append(txns, &types.SignedTxn{TxnHash: hash})
+			hashes = append(hashes, hash)
+		}
+		root := poa.TxnRoot(txns)
+
+		for i := range hashes {
+			proof, err := lightclient.ProveTxn(hashes, i)
+			require.NoError(t, err)
+			require.NoError(t, lightclient.VerifyTxn(root, proof), "txn(%d) of %d", i, n)
+
+			var notCommitted lightclient.ErrTxnNotCommitted
+			other := *proof
+			other.TxnHash = hashes[(i+1)%n]
+			if other.TxnHash != proof.TxnHash {
+				assert.ErrorAs(t, lightclient.VerifyTxn(root, &other), &notCommitted)
+			}
+			other = *proof
+			other.Index += 1 << len(proof.Siblings)
+			assert.ErrorAs(t, lightclient.VerifyTxn(root, &other), &notCommitted)
+			other = *proof
+			other.Count = other.Index
+			assert.ErrorAs(t, lightclient.VerifyTxn(root, &other), &notCommitted)
+		}
+		_, err := lightclient.ProveTxn(hashes, n)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/nine-tripods/consensus/poa/lightclient"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"testing"
+)
+
+func unjailTxn(t *testing.T, secret string) *types.SignedTxn {
+	pub, _ := keypair.GenSrKeyWithSecret([]byte(secret))
+	wrCall := &common.WrCall{
+		TripodName: "poa",
+		FuncName:   "Unjail",
+		Params:     "{}",
+	}
+	txn, err := types.NewSignedTxn(wrCall, pub.BytesWithType(), pub.Address().Bytes(), nil)
+	require.NoError(t, err)
+	return txn
+}
+
+func uptimeOf(t *testing.T, node *testNode, addr common.Address) *poa.ValidatorUptime {
+	uptimes, err := node.poa.GetUptimes()
+	require.NoError(t, err)
+	for _, uptime := range uptimes {
+		if uptime.Validator == addr {
+			return uptime
+		}
+	}
+	t.Fatalf("no uptime of validator(%s)", addr.String())
+	return nil
+}
+
+func TestJailOfflineValidator(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, len(poa.DefaultSecrets), func(cfg *poa.PoaConfig) {
+		cfg.EpochLength = 4
+		cfg.LivenessWindow = 20
+		cfg.JailThreshold = 2
+	})
+	// node3 is offline, it misses its slots of height 3 and 6
+	offline := nodes[2]
+	alive := nodes[:2]
+	startNodes(t, alive)
+	requireAgree(t, alive, 12)
+	full := nodes[0]
+	offlineAddr := offline.poa.LocalAddress()
+
+	// it is jailed from the next epoch, and skipped by the leaders of round 0 since then
+	for height := common.BlockNum(1); height <= 12; height++ {
+		round := uint64(0)
+		if height == 3 || height == 6 {
+			round = 1
+		}
+		assert.Equal(t, round, headerAt(t, full, height).Nonce, "round of height(%d)", height)
+	}
+	assert.NotContains(t, full.poa.LeadersAt(9).Addrs, offlineAddr)
+	assert.Contains(t, full.poa.LeadersAt(8).Addrs, offlineAddr)
+
+	uptime := uptimeOf(t, full, offlineAddr)
+	assert.Equal(t, 2, uptime.Slots)
+	assert.Equal(t, 2, uptime.Missed)
+	assert.Equal(t, float64(0), uptime.Uptime)
+	assert.True(t, uptime.Jailed)
+	require.NotNil(t, uptime.Jail)
+	assert.Equal(t, common.BlockNum(9), uptime.Jail.From)
+	assert.Equal(t, float64(1), uptimeOf(t, full, full.poa.LocalAddress()).Uptime)
+
+	// node3 comes back and unjails itself
+	offline.start(t)
+	waitHeight(t, nodes, full.height()+1)
+	for _, node := range nodes {
+		require.NoError(t, node.kernel.Pool.Insert(unjailTxn(t, poa.DefaultSecrets[2])))
+	}
+	waitMined(t, offline, 12)
+
+	uptime = uptimeOf(t, full, offlineAddr)
+	assert.False(t, uptime.Jailed)
+	assert.Equal(t, 0, uptime.Missed)
+	require.NotNil(t, uptime.Jail)
+	assert.NotZero(t, uptime.Jail.Until)
+
+	// the checkpoint of epoch 2 skips the jailed validator, the light client follows it
+	cp := waitCheckpoint(t, full, 2)
+	assert.Equal(t, []common.Address{offlineAddr}, cp.Jailed)
+
+	infos, err := poa.ResolveValidators(poa.DefaultCfg(0))
+	require.NoError(t, err)
+	genesis, err := full.kernel.Chain.GetGenesis()
+	require.NoError(t, err)
+	client := lightclient.New(lightclient.Config{EpochLength: 4}, genesis.Header, poa.NewValidatorSet(1, infos))
+	for height := common.BlockNum(1); height <= 12; height++ {
+		if height == 5 || height == 9 {
+			require.NoError(t, client.ApplyCheckpoint(waitCheckpoint(t, full, client.Epoch()+1)))
+		}
+		require.NoError(t, client.Verify(headerAt(t, full, height), waitFinalityCert(t, full, height)), "height(%d)", height)
+	}
+}
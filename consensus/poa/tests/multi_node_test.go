@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"testing"
+	"time"
+)
+
+func TestPartition(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, len(poa.DefaultSecrets))
+	startNodes(t, nodes)
+
+	// the majority goes on without node3, which cannot make blocks alone
+	isolated := nodes[2]
+	waitMined(t, isolated, 2)
+	network.partition(nodes[:2], nodes[2:])
+	cut := isolated.height()
+	majority := nodes[:2]
+	// the partition outlasts many rounds of node3, in which it leads some
+	healAt := network.now().Add(4 * time.Duration(len(nodes)) * blockInterval * time.Millisecond)
+	requireAgree(t, majority, cut+4)
+	network.runFor(t, healAt.Sub(network.now()))
+	assert.LessOrEqual(t, isolated.height(), cut+1)
+
+	// node3 follows the majority after the partition heals
+	network.heal()
+	requireAgree(t, nodes, nodes[0].height()+3)
+}
+
+func TestLossyNetwork(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, len(poa.DefaultSecrets))
+	// blocks come late, and node3 never hears the votes of node1
+	network.setRule(func(from, to peer.ID, topic string) (time.Duration, bool) {
+		if topic == poa.VoteTopic && from == nodes[0].id && to == nodes[2].id {
+			return 0, true
+		}
+		if topic == common.StartBlockTopic {
+			return 50 * time.Millisecond, false
+		}
+		return 0, false
+	})
+	startNodes(t, nodes)
+
+	requireAgree(t, nodes, 8)
+}
+
+func TestCrashRestart(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, len(poa.DefaultSecrets))
+	startNodes(t, nodes)
+
+	crashed := nodes[1]
+	waitMined(t, crashed, 3)
+	crashed.crash(t)
+	crashedAt := crashed.height()
+
+	alive := []*testNode{nodes[0], nodes[2]}
+	requireAgree(t, alive, crashedAt+6)
+
+	// node2 restarts on its own storage, and catches up with the others
+	crashed.restart(t)
+	requireAgree(t, nodes, nodes[0].height()+3)
+}
@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"testing"
+)
+
+// nonceTxn is a txn of the sender from secret, with nonce in its params.
+func nonceTxn(t *testing.T, secret string, nonce, tips uint64) *types.SignedTxn {
+	pub, _ := keypair.GenSrKeyWithSecret([]byte(secret))
+	wrCall := &common.WrCall{
+		TripodName: "poa",
+		FuncName:   "RemoveValidator",
+		Params:     fmt.Sprintf(`{"nonce":%d}`, nonce),
+		Tips:       tips,
+	}
+	txn, err := types.NewSignedTxn(wrCall, pub.BytesWithType(), pub.Address().Bytes(), nil)
+	require.NoError(t, err)
+	return txn
+}
+
+func hashes(txns []*types.SignedTxn) []common.Hash {
+	hs := make([]common.Hash, 0)
+	for _, txn := range txns {
+		hs = append(hs, txn.TxnHash)
+	}
+	return hs
+}
+
+func TestTxnOrder(t *testing.T) {
+	a1 := nonceTxn(t, "alice", 1, 10)
+	a2 := nonceTxn(t, "alice", 2, 30)
+	a3 := nonceTxn(t, "alice", 3, 20)
+	b1 := nonceTxn(t, "bob", 1, 40)
+	b2 := nonceTxn(t, "bob", 2, 0)
+	pooled := []*types.SignedTxn{a3, b2, a1, b1, a2}
+
+	cases := []struct {
+		order  string
+		packed []*types.SignedTxn
+	}{
+		{poa.FifoOrder, pooled},
+		{poa.TipOrder, []*types.SignedTxn{b1, a2, a3, a1, b2}},
+		// alice takes the 1st, 3rd and 5th places, bob the 2nd and 4th
+		{poa.NonceOrder, []*types.SignedTxn{a1, b1, a2, b2, a3}},
+	}
+	for _, c := range cases {
+		t.Run(c.order, func(t *testing.T) {
+			node := newTestNode(t, newMemNetwork(), 0, func(cfg *poa.PoaConfig) {
+				cfg.Validators = cfg.Validators[:1]
+				cfg.TxnOrder = c.order
+			})
+			for _, txn := range pooled {
+				require.NoError(t, node.kernel.Pool.Insert(txn))
+			}
+			node.start(t)
+			waitHeight(t, []*testNode{node}, 1)
+
+			block, err := node.kernel.Chain.GetCompactBlock(mustBlockHash(t, node, 1))
+			require.NoError(t, err)
+			assert.Equal(t, hashes(c.packed), block.TxnsHashes)
+		})
+	}
+}
+
+func TestPackSharedNonce(t *testing.T) {
+	a1 := nonceTxn(t, "alice", 1, 10)
+	again := nonceTxn(t, "alice", 1, 20)
+	a2 := nonceTxn(t, "alice", 2, 0)
+
+	node := newTestNode(t, newMemNetwork(), 0, func(cfg *poa.PoaConfig) {
+		cfg.Validators = cfg.Validators[:1]
+		cfg.TxnOrder = poa.NonceOrder
+	})
+	for _, txn := range []*types.SignedTxn{a1, again, a2} {
+		require.NoError(t, node.kernel.Pool.Insert(txn))
+	}
+	node.start(t)
+	waitHeight(t, []*testNode{node}, 2)
+
+	// the repeated nonce waits for the next block, instead of making the block refused by its order
+	block, err := node.kernel.Chain.GetCompactBlock(mustBlockHash(t, node, 1))
+	require.NoError(t, err)
+	assert.Equal(t, hashes([]*types.SignedTxn{a1, a2}), block.TxnsHashes)
+	block, err = node.kernel.Chain.GetCompactBlock(mustBlockHash(t, node, 2))
+	require.NoError(t, err)
+	assert.Equal(t, hashes([]*types.SignedTxn{again}), block.TxnsHashes)
+}
+
+func TestVerifyTxnOrder(t *testing.T) {
+	leader := poa.DefaultSecrets[0]
+	a1 := nonceTxn(t, "alice", 1, 10)
+	a2 := nonceTxn(t, "alice", 2, 30)
+	b1 := nonceTxn(t, "bob", 1, 20)
+
+	cases := []struct {
+		order string
+		good  []*types.SignedTxn
+		bad   []*types.SignedTxn
+	}{
+		{poa.TipOrder, []*types.SignedTxn{a2, b1, a1}, []*types.SignedTxn{a2, a1, b1}},
+		{poa.NonceOrder, []*types.SignedTxn{a1, b1, a2}, []*types.SignedTxn{a2, b1, a1}},
+	}
+	for _, c := range cases {
+		t.Run(c.order, func(t *testing.T) {
+			node := newTestNode(t, newMemNetwork(), 0, func(cfg *poa.PoaConfig) {
+				cfg.TxnOrder = c.order
+			})
+			node.kernel.InitBlockChain()
+			genesis, err := node.kernel.Chain.GetGenesis()
+			require.NoError(t, err)
+			maker := &blockMaker{t: t, node: node, genesis: genesis}
+
+			assert.NoError(t, node.poa.VerifyBlock(maker.makeWith(leader, c.good, nil)))
+
+			var violated poa.ErrTxnOrderViolated
+			if assert.ErrorAs(t, node.poa.VerifyBlock(maker.makeWith(leader, c.bad, nil)), &violated) {
+				assert.Equal(t, c.order, violated.Order)
+			}
+		})
+	}
+
+	// every order passes with fifo
+	node := newTestNode(t, newMemNetwork(), 0)
+	node.kernel.InitBlockChain()
+	genesis, err := node.kernel.Chain.GetGenesis()
+	require.NoError(t, err)
+	maker := &blockMaker{t: t, node: node, genesis: genesis}
+	assert.NoError(t, node.poa.VerifyBlock(maker.makeWith(leader, []*types.SignedTxn{a2, b1, a1}, nil)))
+}
@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"bytes"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/config"
+	"github.com/yu-org/yu/core/keypair"
+	"testing"
+)
+
+// edCfg lists the validators by their ed25519 pubkeys alone.
+func edCfg(idx int) *poa.PoaConfig {
+	cfg := poa.DefaultCfg(idx)
+	cfg.KeyType = keypair.Ed25519
+	cfg.KeyPeerID = true
+	for i, secret := range poa.DefaultSecrets {
+		pub, _ := keypair.GenEdKeyWithSecret([]byte(secret))
+		cfg.Validators[i].Pubkey = pub.StringWithType()
+		cfg.Validators[i].P2pIp = ""
+	}
+	return cfg
+}
+
+func TestKeyPeerID(t *testing.T) {
+	cfg := edCfg(1)
+	infos, err := poa.ResolveValidators(cfg)
+	require.NoError(t, err)
+	for i, info := range infos {
+		require.NotEmpty(t, info.P2pID)
+		if i > 0 {
+			assert.NotEqual(t, infos[i-1].P2pID, info.P2pID)
+		}
+	}
+
+	// the libp2p key made from the validator key has the derived peer id
+	p2pCfg := new(config.P2pConf)
+	require.NoError(t, poa.SetP2pIdentity(p2pCfg, cfg))
+	priv, _, err := crypto.GenerateKeyPairWithReader(p2pCfg.NodeKeyType, p2pCfg.NodeKeyBits,
+		bytes.NewBufferString(p2pCfg.NodeKey))
+	require.NoError(t, err)
+	id, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	assert.Equal(t, infos[1].P2pID, id)
+
+	// the configured peer id must match the pubkey
+	cfg.Validators[0].P2pIp = infos[0].P2pID.String()
+	_, err = poa.ResolveValidators(cfg)
+	assert.NoError(t, err)
+	cfg.Validators[0].P2pIp = infos[2].P2pID.String()
+	_, err = poa.ResolveValidators(cfg)
+	assert.Error(t, err)
+	// so it must without KeyPeerID, once it is configured
+	cfg.KeyPeerID = false
+	_, err = poa.ResolveValidators(cfg)
+	assert.Error(t, err)
+	cfg.Validators[0].P2pIp = infos[0].P2pID.String()
+	_, err = poa.ResolveValidators(cfg)
+	assert.NoError(t, err)
+	cfg.KeyPeerID = true
+
+	// sr25519 keys cannot derive peer ids
+	srCfg := poa.DefaultCfg(0)
+	srCfg.KeyPeerID = true
+	_, err = poa.ResolveValidators(srCfg)
+	assert.Error(t, err)
+	assert.Error(t, poa.SetP2pIdentity(new(config.P2pConf), srCfg))
+	srCfg.KeyPeerID = false
+	_, err = poa.ResolveValidators(srCfg)
+	assert.NoError(t, err)
+}
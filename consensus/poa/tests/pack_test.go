@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"strings"
+	"testing"
+)
+
+// insertTxns puts txns straight into the txpool of node, heavy ones first.
+func insertTxns(t *testing.T, node *testNode, heavy, light int) []*types.SignedTxn {
+	pub, _ := keypair.GenSrKeyWithSecret([]byte("packer"))
+	txns := make([]*types.SignedTxn, 0)
+	for i := 0; i < heavy+light; i++ {
+		kind := "light"
+		if i < heavy {
+			kind = "heavy"
+		}
+		wrCall := &common.WrCall{
+			TripodName: "poa",
+			FuncName:   "RemoveValidator",
+			Params:     fmt.Sprintf(`{"kind":"%s","n":%d}`, kind, i),
+		}
+		txn, err := types.NewSignedTxn(wrCall, pub.BytesWithType(), pub.Address().Bytes(), nil)
+		require.NoError(t, err)
+		require.NoError(t, node.kernel.Pool.Insert(txn))
+		txns = append(txns, txn)
+	}
+	return txns
+}
+
+func packedCounts(t *testing.T, node *testNode, to common.BlockNum) []int {
+	counts := make([]int, 0)
+	for height := common.BlockNum(1); height <= to; height++ {
+		block, err := node.kernel.Chain.GetCompactBlock(mustBlockHash(t, node, height))
+		require.NoError(t, err)
+		count := 0
+		for _, hash := range block.TxnsHashes {
+			// an empty block is stored with a null txn hash
+			if hash != common.NullHash {
+				count++
+			}
+		}
+		counts = append(counts, count)
+	}
+	return counts
+}
+
+func TestPackByCost(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0, func(cfg *poa.PoaConfig) {
+		cfg.Validators = cfg.Validators[:1]
+		cfg.MaxBlockCost = 2
+	})
+	node.poa.SetTxnCost(func(txn *types.SignedTxn) uint64 {
+		if strings.Contains(txn.Raw.WrCall.Params, "heavy") {
+			return 3
+		}
+		return 1
+	})
+	txns := insertTxns(t, node, 1, 5)
+	node.start(t)
+	waitHeight(t, []*testNode{node}, 4)
+
+	// the heavy txn never fits in a block, the rest fill the blocks up to the cost limit
+	assert.Equal(t, []int{2, 2, 1, 0}, packedCounts(t, node, 4))
+	assert.True(t, node.kernel.Pool.Exist(txns[0].TxnHash))
+}
+
+func TestPackBySize(t *testing.T) {
+	pub, _ := keypair.GenSrKeyWithSecret([]byte("packer"))
+	sample, err := types.NewSignedTxn(&common.WrCall{
+		TripodName: "poa",
+		FuncName:   "RemoveValidator",
+		Params:     `{"kind":"light","n":0}`,
+	}, pub.BytesWithType(), pub.Address().Bytes(), nil)
+	require.NoError(t, err)
+	byt, err := sample.Encode()
+	require.NoError(t, err)
+
+	node := newTestNode(t, newMemNetwork(), 0, func(cfg *poa.PoaConfig) {
+		cfg.Validators = cfg.Validators[:1]
+		// room for 3 txns, not 4
+		cfg.MaxBlockSize = uint64(len(byt))*4 - 1
+		cfg.TxnCheck.MaxTxnSize = len(byt) * 2
+	})
+	insertTxns(t, node, 0, 5)
+	node.start(t)
+	waitHeight(t, []*testNode{node}, 3)
+
+	assert.Equal(t, []int{3, 2, 0}, packedCounts(t, node, 3))
+}
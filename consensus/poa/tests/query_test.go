@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"testing"
+)
+
+func TestQueries(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, len(poa.DefaultSecrets))
+	startNodes(t, nodes)
+	requireAgree(t, nodes, 6)
+
+	cfg := poa.DefaultCfg(0)
+	for _, node := range nodes {
+		validators := node.poa.GetValidators(0)
+		require.Len(t, validators, len(cfg.Validators))
+		for i, validator := range validators {
+			assert.Equal(t, cfg.Validators[i].Pubkey, validator.Pubkey)
+			assert.Equal(t, cfg.Validators[i].P2pIp, validator.P2pID)
+			assert.False(t, validator.Jailed)
+		}
+
+		status := node.poa.GetNodeStatus()
+		assert.Equal(t, node.idx, status.Index)
+		assert.True(t, status.IsValidator)
+		assert.Equal(t, node.poa.LocalAddress(), status.Address)
+		assert.Equal(t, cfg.Validators[node.idx].Pubkey, status.Pubkey)
+		assert.GreaterOrEqual(t, status.Height, common.BlockNum(6))
+	}
+
+	// the leaders of the produced heights mined them, the later ones follow the schedule
+	leaders, err := nodes[0].poa.GetLeaders(1, 9)
+	require.NoError(t, err)
+	require.Len(t, leaders, 9)
+	for i, leader := range leaders {
+		height := common.BlockNum(i + 1)
+		assert.Equal(t, height, leader.Height)
+		assert.Equal(t, nodes[i%3].poa.LocalAddress(), leader.Leader, "leader of height(%d)", height)
+		if height <= 6 {
+			miner, err := keypair.PubKeyFromBytes(headerAt(t, nodes[0], height).MinerPubkey)
+			require.NoError(t, err)
+			assert.Equal(t, miner.Address(), leader.Leader, "miner of height(%d)", height)
+		}
+	}
+
+	_, err = nodes[0].poa.GetLeaders(5, 4)
+	assert.Error(t, err)
+	_, err = nodes[0].poa.GetLeaders(1, 5000)
+	assert.Error(t, err)
+}
@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/common/yerror"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"testing"
+)
+
+// leaderSecret returns the secret of the round-0 leader of height.
+func leaderSecret(t *testing.T, node *testNode, height common.BlockNum) string {
+	leader := node.poa.CompeteLeader(height)
+	for _, secret := range poa.DefaultSecrets {
+		pub, _ := keypair.GenSrKeyWithSecret([]byte(secret))
+		if pub.Address() == leader {
+			return secret
+		}
+	}
+	require.FailNow(t, "no leader", "height(%d)", height)
+	return ""
+}
+
+func TestReceiveBlock(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0)
+	node.kernel.InitBlockChain()
+	genesis, err := node.kernel.Chain.GetGenesis()
+	require.NoError(t, err)
+	maker := &blockMaker{t: t, node: node, genesis: genesis}
+	leader := leaderSecret(t, node, 1)
+
+	// forged blocks are rejected before buffered
+	var notValidator poa.ErrMinerNotValidator
+	assert.ErrorAs(t, node.poa.ReceiveBlock(maker.make("other", nil)), &notValidator)
+
+	var notLeader poa.ErrMinerNotLeader
+	assert.ErrorAs(t, node.poa.ReceiveBlock(maker.make(leaderSecret(t, node, 2), nil)), &notLeader)
+
+	var hashMismatch poa.ErrBlockHashMismatch
+	block := maker.make(leader, nil)
+	block.TxnRoot = common.HexToHash("0x1234")
+	assert.ErrorAs(t, node.poa.ReceiveBlock(block), &hashMismatch)
+
+	var sigIllegal yerror.ErrBlockSignatureIllegal
+	block = maker.make(leader, nil)
+	block.MinerSignature = maker.make(leader, func(block *types.Block) {
+		block.Timestamp--
+	}).MinerSignature
+	assert.ErrorAs(t, node.poa.ReceiveBlock(block), &sigIllegal)
+
+	// a block from the scheduled leader is buffered once
+	block1 := maker.make(leader, nil)
+	require.NoError(t, node.poa.ReceiveBlock(block1))
+	var duplicate poa.ErrDuplicateBlock
+	assert.ErrorAs(t, node.poa.ReceiveBlock(block1), &duplicate)
+
+	// the parent of an early block may not arrive yet
+	require.NoError(t, node.poa.ReceiveBlock(maker.make(leaderSecret(t, node, 5), func(block *types.Block) {
+		block.Height = 5
+		block.PrevHash = common.HexToHash("0x5678")
+	})))
+
+	var tooFar poa.ErrBlockTooFarAhead
+	err = node.poa.ReceiveBlock(maker.make(leaderSecret(t, node, 1000), func(block *types.Block) {
+		block.Height = 1000
+	}))
+	assert.ErrorAs(t, err, &tooFar)
+
+	var stale poa.ErrStaleBlock
+	require.NoError(t, node.kernel.Chain.AppendBlock(block1))
+	err = node.poa.ReceiveBlock(maker.make(leader, func(block *types.Block) {
+		block.Timestamp++
+	}))
+	assert.ErrorAs(t, err, &stale)
+}
+
+func TestForgedBlocksIgnored(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, len(poa.DefaultSecrets))
+	startNodes(t, nodes)
+	waitHeight(t, nodes, 2)
+
+	// blocks for the coming heights are forged by an outsider,
+	// or by the scheduled leader but with txns mismatching the txn-root.
+	end, err := nodes[0].kernel.Chain.GetEndCompactBlock()
+	require.NoError(t, err)
+	genesis, err := nodes[0].kernel.Chain.GetGenesis()
+	require.NoError(t, err)
+	maker := &blockMaker{t: t, node: nodes[0], genesis: genesis}
+	forged := make(map[common.Hash]struct{})
+	for height := end.Height + 1; height <= end.Height+4; height++ {
+		onHeight := func(block *types.Block) {
+			block.Height = height
+			block.PrevHash = end.Hash
+		}
+		tampered := maker.make(leaderSecret(t, nodes[0], height), onHeight)
+		tampered.Txns = tampered.Txns[:1]
+		for _, block := range []*types.Block{maker.make("attacker", onHeight), tampered} {
+			forged[block.Hash] = struct{}{}
+			byt, err := block.Encode()
+			require.NoError(t, err)
+			require.NoError(t, network.nodes[nodes[0].id].PubP2P(common.StartBlockTopic, byt))
+		}
+	}
+
+	waitHeight(t, nodes, end.Height+6)
+	for height := end.Height + 1; height <= end.Height+6; height++ {
+		for _, node := range nodes {
+			blocks, err := node.kernel.Chain.GetAllCompactBlocksByHeight(height)
+			require.NoError(t, err)
+			require.Len(t, blocks, 1, "height(%d) forks", height)
+			assert.NotContains(t, forged, blocks[0].Hash)
+		}
+	}
+}
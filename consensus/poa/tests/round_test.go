@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLeaderOffline(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, len(poa.DefaultSecrets))
+	startNodes(t, nodes)
+
+	waitHeight(t, nodes, 4)
+
+	// all validators are online, blocks are finalized by their votes.
+	for _, node := range nodes {
+		waitFinalityCert(t, node, 2)
+	}
+
+	// node3 is the leader of height 6 and 9
+	offline := nodes[2]
+	offline.crash(t)
+	alive := nodes[:2]
+
+	requireAgree(t, alive, 12)
+
+	// the backup leader (node1) proposes for the offline one in round 1
+	for _, height := range []common.BlockNum{6, 9, 12} {
+		blocks, err := alive[0].kernel.Chain.GetAllCompactBlocksByHeight(height)
+		require.NoError(t, err)
+		miner, err := keypair.PubKeyFromBytes(blocks[0].MinerPubkey)
+		require.NoError(t, err)
+		assert.Equal(t, alive[0].poa.LocalAddress(), miner.Address(), "miner of height(%d)", height)
+		assert.Equal(t, uint64(1), blocks[0].Nonce, "round of height(%d)", height)
+	}
+}
+
+func TestLateBlockAfterRoundChange(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, 4, withValidators(4))
+	// the clocks stay still until the network runs, no block is proposed before the rule is set
+	startNodes(t, nodes)
+	genesis, err := nodes[0].kernel.Chain.GetGenesis()
+	require.NoError(t, err)
+	leaderOf := func(round uint64) *testNode {
+		leader, err := nodes[0].poa.LeaderOf(1, genesis.Hash, round)
+		require.NoError(t, err)
+		for _, node := range nodes {
+			if node.poa.LocalAddress() == leader {
+				return node
+			}
+		}
+		require.FailNow(t, "no leader", "round(%d)", round)
+		return nil
+	}
+	late, backup := leaderOf(0), leaderOf(1)
+
+	// the round-0 block of height 1 comes after the others have moved to round 1,
+	// and before the round-1 block does.
+	var (
+		lock sync.Mutex
+		sent = make(map[peer.ID]map[peer.ID]bool)
+	)
+	network.setRule(func(from, to peer.ID, topic string) (time.Duration, bool) {
+		if topic != common.StartBlockTopic || (from != late.id && from != backup.id) {
+			return 0, false
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if sent[from] == nil {
+			sent[from] = make(map[peer.ID]bool)
+		}
+		if sent[from][to] {
+			return 0, false
+		}
+		sent[from][to] = true
+		if from == late.id {
+			return blockInterval*time.Millisecond + 50*time.Millisecond, false
+		}
+		return 100 * time.Millisecond, false
+	})
+
+	// the validators who sent round-changes keep to round 1, the block of round 0 cannot be finalized
+	others := make([]*testNode, 0)
+	for _, node := range nodes {
+		if node != late {
+			others = append(others, node)
+		}
+	}
+	requireAgree(t, others, 4)
+	assert.Equal(t, uint64(1), headerAt(t, others[0], 1).Nonce)
+	cert := waitFinalityCert(t, others[0], 1)
+	assert.Equal(t, mustBlockHash(t, others[0], 1), cert.BlockHash)
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"testing"
+)
+
+func weightedSet(weights ...uint64) *poa.ValidatorSet {
+	infos := make([]poa.ValidatorInfo, 0)
+	for i, weight := range weights {
+		pub, _ := keypair.GenSrKeyWithSecret([]byte(fmt.Sprintf("validator-%d", i)))
+		infos = append(infos, poa.ValidatorInfo{Pubkey: pub, Weight: weight})
+	}
+	return poa.NewValidatorSet(1, infos)
+}
+
+func countProposers(set *poa.ValidatorSet, from, to common.BlockNum) map[common.Address]int {
+	counts := make(map[common.Address]int)
+	for height := from; height <= to; height++ {
+		counts[set.Proposer(height)]++
+	}
+	return counts
+}
+
+func TestRoundRobinSchedule(t *testing.T) {
+	for _, set := range []*poa.ValidatorSet{weightedSet(0, 0, 0), weightedSet(1, 1, 1), weightedSet(7, 7, 7)} {
+		for height := common.BlockNum(1); height <= 30; height++ {
+			assert.Equal(t, set.Addrs[(height-1)%3], set.Proposer(height))
+		}
+	}
+}
+
+func TestWeightedScheduleDistribution(t *testing.T) {
+	weights := []uint64{5, 3, 1, 1}
+	set := weightedSet(weights...)
+	require.Len(t, set.Slots(), 10)
+
+	// every cycle, wherever it starts, gives each validator as many slots as its weight
+	for start := common.BlockNum(1); start <= 10; start++ {
+		counts := countProposers(set, start, start+9)
+		for i, addr := range set.Addrs {
+			assert.Equal(t, int(weights[i]), counts[addr], "validator(%d) from height(%d)", i, start)
+		}
+	}
+
+	// over a long run the share is proportional to the weight
+	counts := countProposers(set, 1, 10000)
+	for i, addr := range set.Addrs {
+		assert.Equal(t, int(weights[i])*1000, counts[addr])
+	}
+}
+
+func TestWeightedScheduleIsSmooth(t *testing.T) {
+	weights := []uint64{3, 1, 1}
+	set := weightedSet(weights...)
+	slots := set.Slots()
+	require.Len(t, slots, 5)
+
+	// a heavy validator is interleaved with the others rather than proposing all its slots in a row
+	assert.Equal(t, []common.Address{set.Addrs[0], set.Addrs[1], set.Addrs[0], set.Addrs[2], set.Addrs[0]}, slots)
+
+	// the gap between two slots of a validator is never much longer than total/weight
+	for i, addr := range set.Addrs {
+		maxGap := int(5/weights[i]) + 1
+		last := -1
+		for j := 0; j < 2*len(slots); j++ {
+			if slots[j%len(slots)] != addr {
+				continue
+			}
+			if last >= 0 {
+				assert.LessOrEqual(t, j-last, maxGap, "validator(%d)", i)
+			}
+			last = j
+		}
+	}
+}
+
+func TestWeightedScheduleDeterministic(t *testing.T) {
+	a := weightedSet(4, 2, 6, 1)
+	b := weightedSet(4, 2, 6, 1)
+	assert.Equal(t, a.Slots(), b.Slots())
+
+	// weights with a common divisor make the same schedule
+	c := weightedSet(8, 4, 12, 2)
+	assert.Equal(t, a.Slots(), c.Slots())
+}
@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/types"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignGuard(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "poa")
+	withDb := func(cfg *poa.PoaConfig) {
+		cfg.DbPath = dbPath
+	}
+	header := func(height common.BlockNum, round, timestamp uint64) *types.Header {
+		return &types.Header{Height: height, Nonce: round, Timestamp: timestamp}
+	}
+	assertRefused := func(err error) {
+		var refused poa.ErrSignRefused
+		assert.ErrorAs(t, err, &refused)
+	}
+
+	node := newTestNode(t, newMemNetwork(), 0, withDb)
+	block := header(5, 0, 1)
+	sig, err := node.poa.SignBlock(block)
+	require.NoError(t, err)
+	_, err = node.poa.SignBlock(header(5, 0, 2))
+	assertRefused(err)
+	require.NoError(t, node.poa.Close())
+
+	// the validator crashes after signing, and restarts
+	node = newTestNode(t, newMemNetwork(), 0, withDb)
+	again, err := node.poa.SignBlock(block)
+	require.NoError(t, err)
+	assert.Equal(t, sig, again)
+	_, err = node.poa.SignBlock(header(5, 0, 2))
+	assertRefused(err)
+	_, err = node.poa.SignBlock(header(4, 2, 2))
+	assertRefused(err)
+	require.NoError(t, node.poa.Close())
+
+	// the override of another height does nothing
+	node = newTestNode(t, newMemNetwork(), 0, withDb, func(cfg *poa.PoaConfig) {
+		cfg.SignGuardOverride = 4
+	})
+	_, err = node.poa.SignBlock(header(5, 0, 2))
+	assertRefused(err)
+	require.NoError(t, node.poa.Close())
+
+	// the operator knows the signed block was never published
+	node = newTestNode(t, newMemNetwork(), 0, withDb, func(cfg *poa.PoaConfig) {
+		cfg.SignGuardOverride = 5
+	})
+	_, err = node.poa.SignBlock(header(5, 0, 2))
+	assert.NoError(t, err)
+	require.NoError(t, node.poa.Close())
+}
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// serveSigner runs a SignerServer with the key of secret on a unix socket, and returns the socket path.
+func serveSigner(t *testing.T, secret, statePath string) string {
+	pub, priv := keypair.GenSrKeyWithSecret([]byte(secret))
+	server, err := poa.NewSignerServer(poa.NewLocalSigner(pub, priv), statePath)
+	require.NoError(t, err)
+	socket := filepath.Join(t.TempDir(), "signer.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go server.Serve(listener)
+	return socket
+}
+
+func TestRemoteSigner(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	socket := serveSigner(t, poa.DefaultSecrets[0], statePath)
+	signer, err := poa.DialRemoteSigner(socket)
+	require.NoError(t, err)
+	pub, priv := keypair.GenSrKeyWithSecret([]byte(poa.DefaultSecrets[0]))
+	require.Equal(t, pub.StringWithType(), signer.PubKey().StringWithType())
+
+	header := func(height common.BlockNum, round, timestamp uint64) *types.Header {
+		return &types.Header{Height: height, Nonce: round, Timestamp: timestamp}
+	}
+	block := header(5, 0, 1)
+	sig, err := signer.SignBlock(block)
+	require.NoError(t, err)
+	assert.True(t, pub.VerifySignature(poa.HeaderHash(block).Bytes(), sig))
+	// the same block is signed again
+	again, err := signer.SignBlock(block)
+	require.NoError(t, err)
+	assert.Equal(t, sig, again)
+
+	// another block on the signed height and round, or below it
+	_, err = signer.SignBlock(header(5, 0, 2))
+	assert.ErrorContains(t, err, "refuse to sign")
+	_, err = signer.SignBlock(header(4, 3, 1))
+	assert.ErrorContains(t, err, "refuse to sign")
+	_, err = signer.SignBlock(header(5, 1, 2))
+	assert.NoError(t, err)
+
+	vote := &poa.Vote{Height: 5, BlockHash: poa.HeaderHash(block)}
+	sig, err = signer.SignVote(vote)
+	require.NoError(t, err)
+	assert.True(t, pub.VerifySignature(vote.SignHash(), sig))
+	again, err = signer.SignVote(vote)
+	require.NoError(t, err)
+	assert.Equal(t, sig, again)
+	// another block on the voted height, or a lower height
+	_, err = signer.SignVote(&poa.Vote{Height: 5, BlockHash: poa.HeaderHash(header(5, 1, 2))})
+	assert.ErrorContains(t, err, "refuse to sign vote")
+	_, err = signer.SignVote(&poa.Vote{Height: 4, BlockHash: poa.HeaderHash(header(4, 0, 1))})
+	assert.ErrorContains(t, err, "refuse to sign vote")
+
+	rc := &poa.RoundChange{Height: 5, Round: 2}
+	sig, err = signer.SignRoundChange(rc)
+	require.NoError(t, err)
+	assert.True(t, pub.VerifySignature(rc.SignHash(), sig))
+	again, err = signer.SignRoundChange(rc)
+	require.NoError(t, err)
+	assert.Equal(t, sig, again)
+	_, err = signer.SignRoundChange(&poa.RoundChange{Height: 5, Round: 1})
+	assert.ErrorContains(t, err, "refuse to sign round-change")
+	_, err = signer.SignRoundChange(&poa.RoundChange{Height: 6, Round: 0})
+	assert.NoError(t, err)
+
+	// the high-water mark survives restarting
+	restarted, err := poa.NewSignerServer(poa.NewLocalSigner(pub, priv), statePath)
+	require.NoError(t, err)
+	_, err = restarted.SignBlock(header(5, 1, 3))
+	var refused poa.ErrSignRefused
+	require.ErrorAs(t, err, &refused)
+	assert.Equal(t, common.BlockNum(5), refused.SignedHeight)
+	assert.Equal(t, uint64(1), refused.SignedRound)
+	_, err = restarted.SignBlock(header(6, 0, 3))
+	assert.NoError(t, err)
+	_, err = restarted.SignVote(&poa.Vote{Height: 5, BlockHash: poa.HeaderHash(header(5, 1, 2))})
+	require.ErrorAs(t, err, &refused)
+	assert.Equal(t, "vote", refused.Kind)
+	_, err = restarted.SignRoundChange(&poa.RoundChange{Height: 6, Round: 0})
+	assert.NoError(t, err)
+}
+
+func TestRemoteSignerNode(t *testing.T) {
+	socket := serveSigner(t, poa.DefaultSecrets[0], filepath.Join(t.TempDir(), "state.json"))
+
+	network := newMemNetwork()
+	nodes := []*testNode{
+		newTestNode(t, network, 0, func(cfg *poa.PoaConfig) {
+			cfg.MySecret = ""
+			cfg.RemoteSigner = socket
+		}),
+		newTestNode(t, network, 1),
+		newTestNode(t, network, 2),
+	}
+	startNodes(t, nodes)
+	waitHeight(t, nodes, 6)
+
+	pub, _ := keypair.GenSrKeyWithSecret([]byte(poa.DefaultSecrets[0]))
+	mined := 0
+	for height := common.BlockNum(1); height <= 6; height++ {
+		block, err := nodes[1].kernel.Chain.GetCompactBlock(mustBlockHash(t, nodes[1], height))
+		require.NoError(t, err)
+		if bytes.Equal(block.MinerPubkey, pub.BytesWithType()) {
+			mined++
+		}
+	}
+	assert.Positive(t, mined, "the node with remote signer mines no block")
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/yu-org/nine-tripods/consensus/poa"
 	"github.com/yu-org/yu/apps/asset"
+	"github.com/yu-org/yu/config"
 	"github.com/yu-org/yu/core/keypair"
 	"github.com/yu-org/yu/core/startup"
 	"github.com/yu-org/yu/core/types"
@@ -11,6 +12,7 @@ import (
 	"github.com/yu-org/yu/example/client/callchain"
 
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
@@ -20,20 +22,33 @@ func TestPoa(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 
-	go runChain(&wg)
+	dir, err := os.MkdirTemp("", "poa-single")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	go runChain(&wg, dir)
 	time.Sleep(2 * time.Second)
 	transferAsset(t)
 	wg.Wait()
 }
 
-func runChain(wg *sync.WaitGroup) {
+func runChain(wg *sync.WaitGroup, dir string) {
 	poaCfg := poa.DefaultCfg(0)
+	// the only node makes every block
+	poaCfg.Validators = poaCfg.Validators[:1]
+	poaCfg.DbPath = filepath.Join(dir, "poa")
 	//poaCfg.BlockInterval = 2
-	yuCfg := startup.InitDefaultKernelConfig()
+	// not by startup.InitDefaultKernelConfig, which sends the logs of all tests to the log file
+	yuCfg := config.InitDefaultCfg()
 	yuCfg.MaxBlockNum = 10
-
-	// reset the history data
-	os.RemoveAll(yuCfg.DataDir)
+	yuCfg.DataDir = dir
+	yuCfg.EnablePProf = false
+	// the clients of the example dial the default http and ws ports, the p2p port is any free one
+	yuCfg.P2P.P2pListenAddrs = []string{"/ip4/127.0.0.1/tcp/0"}
 
 	assetTri := asset.NewAsset("yu-coin")
 	poaTri := poa.NewPoa(poaCfg)
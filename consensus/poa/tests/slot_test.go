@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/types"
+	"testing"
+	"time"
+)
+
+func lateness(t *testing.T, source string) (count uint64, sum float64) {
+	m := new(dto.Metric)
+	require.NoError(t, poa.BlockLateness.WithLabelValues(source).(prometheus.Histogram).Write(m))
+	return m.Histogram.GetSampleCount(), m.Histogram.GetSampleSum()
+}
+
+func TestSlotTiming(t *testing.T) {
+	interval := 3 * time.Second
+	node := newTestNode(t, newMemNetwork(), 0, func(cfg *poa.PoaConfig) {
+		cfg.BlockInterval = int(interval.Milliseconds())
+		cfg.Validators = cfg.Validators[:1]
+	})
+	node.start(t)
+	clk := node.clock
+	count, sum := lateness(t, "proposed")
+
+	clk.BlockUntil(1)
+	clk.Advance(interval)
+	waitHeight(t, []*testNode{node}, 1)
+
+	// the block of height 2 is produced 2s after its slot
+	clk.BlockUntil(1)
+	clk.Advance(interval + 2*time.Second)
+	waitHeight(t, []*testNode{node}, 2)
+	lateCount, lateSum := lateness(t, "proposed")
+	assert.GreaterOrEqual(t, lateCount, count+2)
+	assert.GreaterOrEqual(t, lateSum, sum+2)
+
+	// the next block keeps to its slot instead of an interval after the late one
+	clk.BlockUntil(1)
+	clk.Advance(interval - 2*time.Second)
+	waitHeight(t, []*testNode{node}, 3)
+
+	blocks := make([]*types.CompactBlock, 0)
+	for height := common.BlockNum(1); height <= 3; height++ {
+		block, err := node.kernel.Chain.GetCompactBlock(mustBlockHash(t, node, height))
+		require.NoError(t, err)
+		blocks = append(blocks, block)
+	}
+	assert.Equal(t, uint64(5), blocks[1].Timestamp-blocks[0].Timestamp)
+	assert.Equal(t, uint64(6), blocks[2].Timestamp-blocks[0].Timestamp)
+}
+
+func TestSlotsResumeAfterPause(t *testing.T) {
+	interval := 3 * time.Second
+	node := newTestNode(t, newMemNetwork(), 0, func(cfg *poa.PoaConfig) {
+		cfg.BlockInterval = int(interval.Milliseconds())
+		cfg.Validators = cfg.Validators[:1]
+	})
+	node.start(t)
+	clk := node.clock
+
+	clk.BlockUntil(1)
+	clk.Advance(interval)
+	waitHeight(t, []*testNode{node}, 1)
+
+	// the chain stalls for 10 slots, the block of height 2 is produced at once when it resumes
+	clk.BlockUntil(1)
+	clk.Advance(10 * interval)
+	waitHeight(t, []*testNode{node}, 2)
+
+	// the slots passed in the stall do not begin at once, the next one is an interval after the parent
+	clk.BlockUntil(1)
+	assert.Equal(t, common.BlockNum(2), node.height())
+	clk.Advance(interval)
+	waitHeight(t, []*testNode{node}, 3)
+	clk.BlockUntil(1)
+	clk.Advance(interval)
+	waitHeight(t, []*testNode{node}, 4)
+
+	blocks := make([]*types.CompactBlock, 0)
+	for height := common.BlockNum(2); height <= 4; height++ {
+		block, err := node.kernel.Chain.GetCompactBlock(mustBlockHash(t, node, height))
+		require.NoError(t, err)
+		blocks = append(blocks, block)
+	}
+	assert.Equal(t, uint64(3), blocks[1].Timestamp-blocks[0].Timestamp)
+	assert.Equal(t, uint64(3), blocks[2].Timestamp-blocks[1].Timestamp)
+}
@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"testing"
+)
+
+func TestStateRootMismatch(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, len(poa.DefaultSecrets))
+	startNodes(t, nodes)
+
+	waitHeight(t, nodes, 3)
+	for _, node := range nodes {
+		mismatches, err := node.poa.GetStateRootMismatches(0)
+		require.NoError(t, err)
+		assert.Empty(t, mismatches)
+	}
+
+	// node3 votes for block 2 with a wrong state root
+	block, err := nodes[0].kernel.Chain.GetCompactBlock(mustBlockHash(t, nodes[0], 2))
+	require.NoError(t, err)
+	pub, priv := keypair.GenSrKeyWithSecret([]byte(poa.DefaultSecrets[2]))
+	vote := &poa.Vote{
+		Height:      block.Height,
+		BlockHash:   block.Hash,
+		StateRoot:   common.HexToHash("0xdead"),
+		ReceiptRoot: block.ReceiptRoot,
+		Pubkey:      pub.BytesWithType(),
+	}
+	vote.Signature, err = priv.SignData(vote.SignHash())
+	require.NoError(t, err)
+	byt, err := vote.Encode()
+	require.NoError(t, err)
+	require.NoError(t, network.nodes[nodes[2].id].PubP2P(poa.VoteTopic, byt))
+
+	for _, node := range nodes[:2] {
+		network.runUntil(t, func() bool {
+			mismatches, err := node.poa.GetStateRootMismatches(0)
+			return err == nil && len(mismatches) == 1 &&
+				mismatches[0].Validator == pub.Address() &&
+				mismatches[0].LocalStateRoot == block.StateRoot &&
+				mismatches[0].RemoteStateRoot == vote.StateRoot
+		}, "state root mismatch on node(%d)", node.idx)
+		assert.False(t, node.poa.Halted())
+	}
+}
+
+func TestReceiptRootOfManyTxns(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, len(poa.DefaultSecrets))
+	// every validator proposes an outsider of its own, and unjails itself in vain,
+	// the leader of height 1 packs them all into one block.
+	txns := make([]*types.SignedTxn, 0)
+	for i, secret := range poa.DefaultSecrets {
+		outsider, _ := keypair.GenSrKeyWithSecret([]byte(fmt.Sprintf("outsider%d", i)))
+		txns = append(txns, addValidatorTxn(t, secret, 13, outsider.StringWithType()), unjailTxn(t, secret))
+	}
+	poolTxns(t, nodes, txns...)
+	startNodes(t, nodes)
+
+	cert := waitFinalityCert(t, nodes[0], 1)
+	header := headerAt(t, nodes[0], 1)
+	block, err := nodes[0].kernel.Chain.GetCompactBlock(header.Hash)
+	require.NoError(t, err)
+	require.Len(t, block.TxnsHashes, len(txns))
+	assert.Equal(t, header.ReceiptRoot, cert.ReceiptRoot)
+	for _, node := range nodes[1:] {
+		assert.Equal(t, header.ReceiptRoot, waitFinalityCert(t, node, 1).ReceiptRoot)
+		assert.Equal(t, header.ReceiptRoot, headerAt(t, node, 1).ReceiptRoot)
+	}
+	for _, node := range nodes {
+		mismatches, err := node.poa.GetStateRootMismatches(0)
+		require.NoError(t, err)
+		assert.Empty(t, mismatches)
+	}
+}
@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/types"
+	"testing"
+	"time"
+)
+
+func TestCatchUp(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, 4, withValidators(4))
+	// three of the four validators make the quorum, node4 joins late
+	late := nodes[3]
+	startNodes(t, nodes[:3])
+	waitHeight(t, nodes[:3], 8)
+	waitFinalityCert(t, nodes[0], 8)
+
+	// node4 replays the finalized blocks instead of mining them
+	late.start(t)
+	requireAgree(t, nodes, 12)
+	for height := common.BlockNum(1); height <= 8; height++ {
+		assert.Equal(t, mustBlockHash(t, nodes[0], height), mustBlockHash(t, late, height), "height(%d)", height)
+		assert.Equal(t, waitFinalityCert(t, nodes[0], height).BlockHash, waitFinalityCert(t, late, height).BlockHash)
+	}
+}
+
+func TestSyncRequestBounds(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, len(poa.DefaultSecrets))
+	startNodes(t, nodes)
+	waitHeight(t, nodes, 4)
+
+	request := func(from, to common.BlockNum) (*poa.SyncResponse, error) {
+		req, err := json.Marshal(&poa.SyncRequest{From: from, To: to})
+		require.NoError(t, err)
+		byt, err := network.nodes[nodes[1].id].RequestPeer(nodes[0].id, poa.SyncBlocksCode, req)
+		if err != nil {
+			return nil, err
+		}
+		resp := new(poa.SyncResponse)
+		require.NoError(t, json.Unmarshal(byt, resp))
+		return resp, nil
+	}
+
+	// the genesis block is not synced, and the blocks not finalized yet follow the finalized ones
+	resp, err := request(0, 100)
+	require.NoError(t, err)
+	blocks, err := types.DecodeBlocks(resp.Blocks)
+	require.NoError(t, err)
+	require.Len(t, blocks, int(resp.EndHeight))
+	for i, block := range blocks {
+		assert.Equal(t, mustBlockHash(t, nodes[0], common.BlockNum(i+1)), block.Hash)
+	}
+
+	// the requests beyond the rate are refused, until the time goes by
+	refused := false
+	for i := 0; i < 100 && !refused; i++ {
+		_, err = request(1, 1)
+		refused = err != nil
+	}
+	assert.True(t, refused)
+	network.runFor(t, 2*time.Second)
+	_, err = request(1, 1)
+	assert.NoError(t, err)
+}
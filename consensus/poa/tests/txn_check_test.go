@@ -0,0 +1,240 @@
+package tests
+
+import (
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/apps/metamask"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"strings"
+	"testing"
+)
+
+func allTxnChecks(cfg *poa.PoaConfig) {
+	cfg.TxnCheck = poa.TxnCheckConf{
+		Signature:  true,
+		Nonce:      true,
+		Payload:    true,
+		MaxTxnSize: 1024,
+	}
+}
+
+func transfer(params string) *common.WrCall {
+	return &common.WrCall{TripodName: "asset", FuncName: "Transfer", Params: params}
+}
+
+// signTxn signs the call by the key, pubkey is the bytes sent in the txn.
+func signTxn(t *testing.T, wrCall *common.WrCall, pubkey []byte, priv keypair.PrivKey) *types.SignedTxn {
+	hash, err := wrCall.Hash()
+	require.NoError(t, err)
+	sig, err := priv.SignData(hash)
+	require.NoError(t, err)
+	txn, err := types.NewSignedTxn(wrCall, pubkey, nil, sig)
+	require.NoError(t, err)
+	return txn
+}
+
+func assertRejected(t *testing.T, err error, check string) {
+	var rejected poa.ErrTxnRejected
+	if assert.ErrorAs(t, err, &rejected) {
+		assert.Equal(t, check, rejected.Check, rejected.Reason)
+	}
+}
+
+func TestTxnSignatures(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0, allTxnChecks)
+
+	srPub, srPriv := keypair.GenSrKeyWithSecret([]byte("sr"))
+	edPub, edPriv := keypair.GenEdKeyWithSecret([]byte("ed"))
+	secpPub, secpPriv := keypair.GenSecpKeyWithSecret([]byte("secp"))
+	keys := []struct {
+		pubkey []byte
+		priv   keypair.PrivKey
+	}{
+		{srPub.BytesWithType(), srPriv},
+		{edPub.BytesWithType(), edPriv},
+		{append([]byte(keypair.Secp256k1Idx), secpPub.Bytes()...), secpPriv},
+	}
+	for _, key := range keys {
+		txn := signTxn(t, transfer(`{"nonce":1}`), key.pubkey, key.priv)
+		assert.NoError(t, node.poa.CheckTxn(txn))
+
+		forged := signTxn(t, transfer(`{"nonce":1}`), key.pubkey, key.priv)
+		forged.Raw.WrCall.Params = `{"nonce":1,"amount":100}`
+		assertRejected(t, node.poa.CheckTxn(forged), poa.SignatureCheck)
+	}
+
+	assertRejected(t, node.poa.CheckTxn(signTxn(t, transfer(`{"nonce":1}`), srPub.BytesWithType(), edPriv)),
+		poa.SignatureCheck)
+
+	unsigned, err := types.NewSignedTxn(transfer(`{"nonce":1}`), srPub.BytesWithType(), nil, nil)
+	require.NoError(t, err)
+	assertRejected(t, node.poa.CheckTxn(unsigned), poa.SignatureCheck)
+
+	// a txn missing the call is rejected without the payload check too
+	sigOnly := newTestNode(t, newMemNetwork(), 0, func(cfg *poa.PoaConfig) {
+		cfg.TxnCheck = poa.TxnCheckConf{Signature: true}
+	})
+	noCall := signTxn(t, transfer(`{"nonce":1}`), srPub.BytesWithType(), srPriv)
+	noCall.Raw.WrCall = nil
+	assertRejected(t, sigOnly.poa.CheckTxn(noCall), poa.SignatureCheck)
+	noCall.Raw = nil
+	assertRejected(t, sigOnly.poa.CheckTxn(noCall), poa.SignatureCheck)
+}
+
+func TestEthTxnSignature(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0, allTxnChecks)
+
+	signEth := func(wrCall *common.WrCall, secret string) *types.SignedTxn {
+		key, err := crypto.ToECDSA(crypto.Keccak256([]byte(secret)))
+		require.NoError(t, err)
+		msg, err := json.Marshal(wrCall)
+		require.NoError(t, err)
+		sig, err := crypto.Sign(metamask.MetamaskMsgHash(msg), key)
+		require.NoError(t, err)
+		// as wallets do
+		sig[crypto.RecoveryIDOffset] += 27
+		txn, err := types.NewSignedTxn(wrCall, crypto.FromECDSAPub(&key.PublicKey), nil, sig)
+		require.NoError(t, err)
+		return txn
+	}
+
+	txn := signEth(transfer(`{"nonce":1}`), "alice")
+	require.NoError(t, node.poa.CheckTxn(txn))
+	sender, err := poa.TxnSender(txn)
+	require.NoError(t, err)
+	key, _ := crypto.ToECDSA(crypto.Keccak256([]byte("alice")))
+	assert.Equal(t, crypto.PubkeyToAddress(key.PublicKey).Bytes(), sender.Bytes())
+
+	forged := signEth(transfer(`{"nonce":2}`), "bob")
+	forged.Pubkey = txn.Pubkey
+	assertRejected(t, node.poa.CheckTxn(forged), poa.SignatureCheck)
+}
+
+func TestTxnPayload(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0, allTxnChecks)
+	pub, priv := keypair.GenSrKeyWithSecret([]byte("sr"))
+
+	large := `{"nonce":1,"memo":"` + strings.Repeat("x", 1024) + `"}`
+	assertRejected(t, node.poa.CheckTxn(signTxn(t, transfer(large), pub.BytesWithType(), priv)), poa.PayloadCheck)
+
+	noWriting := &common.WrCall{TripodName: "asset", Params: `{"nonce":1}`}
+	assertRejected(t, node.poa.CheckTxn(signTxn(t, noWriting, pub.BytesWithType(), priv)), poa.PayloadCheck)
+
+	noCall := signTxn(t, transfer(`{"nonce":1}`), pub.BytesWithType(), priv)
+	noCall.Raw = nil
+	assertRejected(t, node.poa.CheckTxn(noCall), poa.PayloadCheck)
+}
+
+func TestTxnNonce(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0, allTxnChecks)
+	pub, priv := keypair.GenSrKeyWithSecret([]byte("sr"))
+	sign := func(params string) *types.SignedTxn {
+		return signTxn(t, transfer(params), pub.BytesWithType(), priv)
+	}
+
+	txn1 := sign(`{"nonce":1}`)
+	require.NoError(t, node.poa.CheckTxn(txn1))
+	// checking the same txn again is fine
+	require.NoError(t, node.poa.CheckTxn(txn1))
+	// another pending txn cannot take the nonce
+	assertRejected(t, node.poa.CheckTxn(sign(`{"nonce":1,"amount":2}`)), poa.NonceCheck)
+	assertRejected(t, node.poa.CheckTxn(sign(`{"amount":2}`)), poa.NonceCheck)
+	require.NoError(t, node.poa.CheckTxn(sign(`{"nonce":3}`)))
+
+	// txn1 is committed and cannot be replayed
+	block := &types.Block{Header: &types.Header{Height: 1}}
+	block.SetTxns([]*types.SignedTxn{txn1})
+	node.poa.Commit(block)
+	assertRejected(t, node.poa.CheckTxn(txn1), poa.NonceCheck)
+	require.NoError(t, node.poa.CheckTxn(sign(`{"nonce":2}`)))
+}
+
+func TestTxnNonceRelease(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0, allTxnChecks)
+	pub, priv := keypair.GenSrKeyWithSecret([]byte("sr"))
+	sign := func(params string, tips uint64) *types.SignedTxn {
+		call := transfer(params)
+		call.Tips = tips
+		return signTxn(t, call, pub.BytesWithType(), priv)
+	}
+
+	// a txn with higher tips replaces the pending one in the pool
+	low := sign(`{"nonce":1}`, 1)
+	require.NoError(t, node.poa.CheckTxn(low))
+	require.NoError(t, node.kernel.Pool.Insert(low))
+	assertRejected(t, node.poa.CheckTxn(sign(`{"nonce":1,"amount":2}`, 1)), poa.NonceCheck)
+	high := sign(`{"nonce":1,"amount":3}`, 2)
+	require.NoError(t, node.poa.CheckTxn(high))
+	assert.False(t, node.kernel.Pool.Exist(low.TxnHash))
+
+	// the nonce of a txn rejected by a later check is free again
+	node.poa.AddTxnCheck("no-memo", func(txn *types.SignedTxn) error {
+		if strings.Contains(txn.GetParams(), "memo") {
+			return errors.New("memo is closed")
+		}
+		return nil
+	})
+	assertRejected(t, node.poa.CheckTxn(sign(`{"nonce":2,"memo":"x"}`, 5)), "no-memo")
+	assert.NoError(t, node.poa.CheckTxn(sign(`{"nonce":2}`, 0)))
+}
+
+func TestTxnNonceZero(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0, allTxnChecks, func(cfg *poa.PoaConfig) {
+		cfg.Validators = cfg.Validators[:1]
+	})
+	pub, priv := keypair.GenSrKeyWithSecret([]byte("sr"))
+	call := &common.WrCall{TripodName: "poa", FuncName: "RemoveValidator", Params: `{"nonce":0}`}
+	txn0 := signTxn(t, call, pub.BytesWithType(), priv)
+	require.NoError(t, node.poa.CheckTxn(txn0))
+	require.NoError(t, node.kernel.Pool.Insert(txn0))
+
+	node.start(t)
+	node.network.runUntil(t, func() bool {
+		block, err := node.kernel.Chain.LastFinalizedCompact()
+		return err == nil && block.Height >= 2
+	}, "wait for height(2) to be finalized")
+	block, err := node.kernel.Chain.GetCompactBlock(mustBlockHash(t, node, 1))
+	require.NoError(t, err)
+	require.Equal(t, []common.Hash{txn0.TxnHash}, block.TxnsHashes)
+
+	// the nonce is read from the finalized state, once the block using it is finalized
+	assertRejected(t, node.poa.CheckTxn(txn0), poa.NonceCheck)
+	next := &common.WrCall{TripodName: "poa", FuncName: "RemoveValidator", Params: `{"nonce":1}`}
+	assert.NoError(t, node.poa.CheckTxn(signTxn(t, next, pub.BytesWithType(), priv)))
+}
+
+func TestCustomTxnCheck(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0)
+	node.poa.AddTxnCheck("no-mint", func(txn *types.SignedTxn) error {
+		if txn.WrName() == "Mint" {
+			return errors.New("mint is closed")
+		}
+		return nil
+	})
+
+	txn, err := types.NewSignedTxn(transfer(`{}`), nil, nil, nil)
+	require.NoError(t, err)
+	assert.NoError(t, node.poa.CheckTxn(txn))
+
+	txn, err = types.NewSignedTxn(&common.WrCall{TripodName: "asset", FuncName: "Mint", Params: `{}`}, nil, nil, nil)
+	require.NoError(t, err)
+	assertRejected(t, node.poa.CheckTxn(txn), "no-mint")
+}
+
+func TestVerifyBlockTxns(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0, allTxnChecks)
+	node.kernel.InitBlockChain()
+	genesis, err := node.kernel.Chain.GetGenesis()
+	require.NoError(t, err)
+	maker := &blockMaker{t: t, node: node, genesis: genesis}
+
+	// the txns made by blockMaker are not signed
+	var rejected poa.ErrTxnRejected
+	assert.ErrorAs(t, node.poa.VerifyBlock(maker.make(poa.DefaultSecrets[0], nil)), &rejected)
+}
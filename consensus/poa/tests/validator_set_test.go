@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"testing"
+)
+
+func validatorChangeTxn(t *testing.T, secret, funcName string, height common.BlockNum, pubkey string) *types.SignedTxn {
+	pub, _ := keypair.GenSrKeyWithSecret([]byte(secret))
+	wrCall := &common.WrCall{
+		TripodName: "poa",
+		FuncName:   funcName,
+		Params:     fmt.Sprintf(`{"height":%d,"pubkey":"%s"}`, height, pubkey),
+	}
+	txn, err := types.NewSignedTxn(wrCall, pub.BytesWithType(), pub.Address().Bytes(), nil)
+	require.NoError(t, err)
+	return txn
+}
+
+func removeValidatorTxn(t *testing.T, secret string, height common.BlockNum, pubkey string) *types.SignedTxn {
+	return validatorChangeTxn(t, secret, "RemoveValidator", height, pubkey)
+}
+
+func addValidatorTxn(t *testing.T, secret string, height common.BlockNum, pubkey string) *types.SignedTxn {
+	return validatorChangeTxn(t, secret, "AddValidator", height, pubkey)
+}
+
+func waitValidatorSet(t *testing.T, node *testNode, height common.BlockNum, size int) {
+	node.network.runUntil(t, func() bool {
+		return node.poa.ValidatorSetAt(height).Len() == size
+	}, "validators of height(%d)", height)
+}
+
+func TestValidatorGovernance(t *testing.T) {
+	network := newMemNetwork()
+	nodes := newTestNodes(t, network, len(poa.DefaultSecrets), func(cfg *poa.PoaConfig) {
+		cfg.EpochLength = 4
+	})
+	full := nodes[0]
+	validators := poa.DefaultCfg(0).Validators
+	outsider, _ := keypair.GenSrKeyWithSecret([]byte("outsider"))
+	v0, _ := keypair.GenSrKeyWithSecret([]byte(poa.DefaultSecrets[0]))
+	v1, _ := keypair.GenSrKeyWithSecret([]byte(poa.DefaultSecrets[1]))
+
+	// the outsider cannot vote, node1 and node2 are not more than 2/3 of the validators,
+	// and node1 alone votes for removing node3.
+	poolTxns(t, nodes,
+		addValidatorTxn(t, "outsider", 13, outsider.StringWithType()),
+		addValidatorTxn(t, poa.DefaultSecrets[0], 13, outsider.StringWithType()),
+		addValidatorTxn(t, poa.DefaultSecrets[1], 13, outsider.StringWithType()),
+		removeValidatorTxn(t, poa.DefaultSecrets[0], 9, validators[2].Pubkey),
+	)
+	startNodes(t, nodes)
+
+	var proposals []*poa.ValidatorProposal
+	network.runUntil(t, func() bool {
+		var err error
+		proposals, err = full.poa.GetValidatorProposals()
+		return err == nil && len(proposals) == 2
+	}, "validator proposals")
+	assert.Equal(t, poa.AddValidatorOp, proposals[0].Change.Op)
+	assert.Equal(t, []common.Address{v0.Address(), v1.Address()}, proposals[0].Voters)
+	assert.Equal(t, poa.RemoveValidatorOp, proposals[1].Change.Op)
+	assert.Equal(t, []common.Address{v0.Address()}, proposals[1].Voters)
+	assert.Equal(t, 3, full.poa.ValidatorSetAt(13).Len())
+
+	// the vote of node3 makes the quorum, the outsider works from height 13
+	poolTxns(t, nodes, addValidatorTxn(t, poa.DefaultSecrets[2], 13, outsider.StringWithType()))
+	waitValidatorSet(t, full, 13, 4)
+	assert.True(t, full.poa.ValidatorSetAt(13).Contains(outsider.Address()))
+	assert.False(t, full.poa.ValidatorSetAt(12).Contains(outsider.Address()))
+	// the removal of node3 misses its height
+	assert.Equal(t, 3, full.poa.ValidatorSetAt(9).Len())
+
+	// 3 of the 4 validators remove the offline outsider from height 17
+	for _, secret := range poa.DefaultSecrets {
+		poolTxns(t, nodes, removeValidatorTxn(t, secret, 17, outsider.StringWithType()))
+	}
+	waitValidatorSet(t, full, 17, 3)
+	assert.False(t, full.poa.ValidatorSetAt(17).Contains(outsider.Address()))
+	assert.True(t, full.poa.ValidatorSetAt(16).Contains(outsider.Address()))
+
+	requireAgree(t, nodes, 18)
+	for _, node := range nodes {
+		for height, size := range map[common.BlockNum]int{9: 3, 13: 4, 17: 3} {
+			assert.Equal(t, size, node.poa.ValidatorSetAt(height).Len(), "validators of height(%d) on node(%d)", height, node.idx)
+		}
+	}
+	// the expired removal is dropped
+	proposals, err := full.poa.GetValidatorProposals()
+	require.NoError(t, err)
+	assert.Empty(t, proposals)
+}
+
+func TestRemoveLastValidator(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0, func(cfg *poa.PoaConfig) {
+		cfg.Validators = cfg.Validators[:1]
+		cfg.EpochLength = 4
+	})
+	poolTxns(t, []*testNode{node}, removeValidatorTxn(t, poa.DefaultSecrets[0], 5, poa.DefaultCfg(0).Validators[0].Pubkey))
+	node.start(t)
+	waitHeight(t, []*testNode{node}, 6)
+
+	assert.Equal(t, 1, node.poa.ValidatorSetAt(6).Len())
+	proposals, err := node.poa.GetValidatorProposals()
+	require.NoError(t, err)
+	assert.Empty(t, proposals)
+}
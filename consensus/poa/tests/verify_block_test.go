@@ -0,0 +1,198 @@
+package tests
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yu-org/nine-tripods/consensus/poa"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/common/yerror"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	ytime "github.com/yu-org/yu/utils/time"
+	"testing"
+)
+
+type blockMaker struct {
+	t       *testing.T
+	node    *testNode
+	genesis *types.Block
+}
+
+// make builds a block on genesis, edit runs before the block is signed.
+func (m *blockMaker) make(secret string, edit func(block *types.Block)) *types.Block {
+	return m.makeWith(secret, transferTxns(m.t, secret, 1, 2), edit)
+}
+
+// transferTxns makes a txn of secret for each n.
+func transferTxns(t *testing.T, secret string, ns ...int) []*types.SignedTxn {
+	pub, _ := keypair.GenSrKeyWithSecret([]byte(secret))
+	txns := make([]*types.SignedTxn, 0, len(ns))
+	for _, n := range ns {
+		txn, err := types.NewSignedTxn(&common.WrCall{TripodName: "asset", FuncName: "Transfer", Params: fmt.Sprintf(`{"n":%d}`, n)},
+			pub.BytesWithType(), pub.Address().Bytes(), nil)
+		require.NoError(t, err)
+		txns = append(txns, txn)
+	}
+	return txns
+}
+
+// makeWith builds a block of txns on genesis.
+func (m *blockMaker) makeWith(secret string, txns []*types.SignedTxn, edit func(block *types.Block)) *types.Block {
+	pub, priv := keypair.GenSrKeyWithSecret([]byte(secret))
+	block := &types.Block{
+		Header: &types.Header{
+			ChainID:   m.node.kernel.Chain.ChainID(),
+			PrevHash:  m.genesis.Hash,
+			Height:    1,
+			TxnRoot:   poa.TxnRoot(txns),
+			Timestamp: ytime.NowTsU64(),
+			PeerID:    m.node.id,
+		},
+	}
+	if edit != nil {
+		edit(block)
+	}
+	block.Hash = poa.HeaderHash(block.Header)
+	var err error
+	block.MinerSignature, err = priv.SignData(block.Hash.Bytes())
+	require.NoError(m.t, err)
+	block.MinerPubkey = pub.BytesWithType()
+	block.SetTxns(txns)
+	return block
+}
+
+func TestVerifyBlock(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0)
+	node.kernel.InitBlockChain()
+	genesis, err := node.kernel.Chain.GetGenesis()
+	require.NoError(t, err)
+	maker := &blockMaker{t: t, node: node, genesis: genesis}
+	leader := poa.DefaultSecrets[0]
+
+	require.NoError(t, node.poa.VerifyBlock(maker.make(leader, nil)))
+
+	var notValidator poa.ErrMinerNotValidator
+	assert.ErrorAs(t, node.poa.VerifyBlock(maker.make("other", nil)), &notValidator)
+
+	var notLeader poa.ErrMinerNotLeader
+	err = node.poa.VerifyBlock(maker.make(leader, func(block *types.Block) {
+		block.Nonce = 1
+	}))
+	assert.ErrorAs(t, err, &notLeader)
+
+	var hashMismatch poa.ErrBlockHashMismatch
+	block := maker.make(leader, nil)
+	block.Timestamp++
+	assert.ErrorAs(t, node.poa.VerifyBlock(block), &hashMismatch)
+
+	var sigIllegal yerror.ErrBlockSignatureIllegal
+	block = maker.make(leader, nil)
+	block.MinerSignature = maker.make(leader, func(block *types.Block) {
+		block.Timestamp--
+	}).MinerSignature
+	assert.ErrorAs(t, node.poa.VerifyBlock(block), &sigIllegal)
+
+	var txnMismatch poa.ErrTxnHashMismatch
+	block = maker.make(leader, nil)
+	block.Txns[0].Raw.WrCall.Params = `{"n":100}`
+	assert.ErrorAs(t, node.poa.VerifyBlock(block), &txnMismatch)
+
+	var rootMismatch poa.ErrTxnRootMismatch
+	block = maker.make(leader, nil)
+	block.Txns = block.Txns[:1]
+	assert.ErrorAs(t, node.poa.VerifyBlock(block), &rootMismatch)
+
+	var parentNotFound poa.ErrParentNotFound
+	err = node.poa.VerifyBlock(maker.make(leader, func(block *types.Block) {
+		block.PrevHash = common.HexToHash("0x1234")
+	}))
+	assert.ErrorAs(t, err, &parentNotFound)
+
+	var notContinuous poa.ErrHeightNotContinuous
+	// node2 is the leader of height 2
+	err = node.poa.VerifyBlock(maker.make(poa.DefaultSecrets[1], func(block *types.Block) {
+		block.Height = 2
+	}))
+	assert.ErrorAs(t, err, &notContinuous)
+
+	var outOfRange poa.ErrTimestampOutOfRange
+	err = node.poa.VerifyBlock(maker.make(leader, func(block *types.Block) {
+		block.Timestamp = ytime.NowTsU64() + 3600
+	}))
+	assert.ErrorAs(t, err, &outOfRange)
+
+	// the timestamp must not go back from the parent
+	block1 := maker.make(leader, nil)
+	require.NoError(t, node.kernel.Chain.AppendBlock(block1))
+	err = node.poa.VerifyBlock(maker.make(poa.DefaultSecrets[1], func(block *types.Block) {
+		block.Height = 2
+		block.PrevHash = block1.Hash
+		block.Timestamp = block1.Timestamp - 1
+	}))
+	assert.ErrorAs(t, err, &outOfRange)
+
+	// a block cannot be stamped before its slot
+	early := newTestNode(t, newMemNetwork(), 0, func(cfg *poa.PoaConfig) {
+		cfg.GenesisTimestamp = ytime.NowTsU64() + 3600
+	})
+	early.kernel.InitBlockChain()
+	genesis, err = early.kernel.Chain.GetGenesis()
+	require.NoError(t, err)
+	maker = &blockMaker{t: t, node: early, genesis: genesis}
+	assert.ErrorAs(t, early.poa.VerifyBlock(maker.make(leader, nil)), &outOfRange)
+
+	// txns of a block are bounded by the size and cost limits
+	var overLimit poa.ErrBlockOverLimit
+	bounded := newTestNode(t, newMemNetwork(), 0, func(cfg *poa.PoaConfig) {
+		cfg.MaxBlockCost = 1
+	})
+	bounded.kernel.InitBlockChain()
+	genesis, err = bounded.kernel.Chain.GetGenesis()
+	require.NoError(t, err)
+	maker = &blockMaker{t: t, node: bounded, genesis: genesis}
+	if assert.ErrorAs(t, bounded.poa.VerifyBlock(maker.make(leader, nil)), &overLimit) {
+		assert.Equal(t, poa.BlockCostLimit, overLimit.Limit)
+		assert.Equal(t, uint64(2), overLimit.Used)
+	}
+
+	bounded = newTestNode(t, newMemNetwork(), 0, func(cfg *poa.PoaConfig) {
+		cfg.MaxBlockSize = 64
+		cfg.TxnCheck.Payload = false
+	})
+	bounded.kernel.InitBlockChain()
+	genesis, err = bounded.kernel.Chain.GetGenesis()
+	require.NoError(t, err)
+	maker = &blockMaker{t: t, node: bounded, genesis: genesis}
+	if assert.ErrorAs(t, bounded.poa.VerifyBlock(maker.make(leader, nil)), &overLimit) {
+		assert.Equal(t, poa.BlockSizeLimit, overLimit.Limit)
+	}
+}
+
+func TestTxnRootCommitsAllTxns(t *testing.T) {
+	node := newTestNode(t, newMemNetwork(), 0)
+	node.kernel.InitBlockChain()
+	genesis, err := node.kernel.Chain.GetGenesis()
+	require.NoError(t, err)
+	maker := &blockMaker{t: t, node: node, genesis: genesis}
+	leader := poa.DefaultSecrets[0]
+
+	for _, n := range []int{3, 5, 7} {
+		ns := make([]int, n)
+		for i := range ns {
+			ns[i] = i + 1
+		}
+		txns := transferTxns(t, leader, ns...)
+		require.NoError(t, node.poa.VerifyBlock(maker.makeWith(leader, txns, nil)), "%d txns", n)
+
+		// every txn is under the root, replacing any of them is caught
+		for i := range txns {
+			block := maker.makeWith(leader, txns, nil)
+			replaced := append([]*types.SignedTxn{}, txns...)
+			replaced[i] = transferTxns(t, leader, 100+i)[0]
+			block.SetTxns(replaced)
+			var rootMismatch poa.ErrTxnRootMismatch
+			assert.ErrorAs(t, node.poa.VerifyBlock(block), &rootMismatch, "txn(%d) of %d", i, n)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package poa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+	"github.com/yu-org/yu/apps/metamask"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"sync"
+	"unicode/utf8"
+)
+
+// names of the built-in txn checks
+const (
+	PayloadCheck   = "payload"
+	SignatureCheck = "signature"
+	NonceCheck     = "nonce"
+)
+
+// the state keeps the next nonce of each sender, so that nonce 0 is usable
+var noncePrefix = []byte("next_nonce_")
+
+// TxnCheckFn is one step of the txn admission in Poa, a txn is rejected if any step returns an error.
+type TxnCheckFn func(txn *types.SignedTxn) error
+
+type txnCheck struct {
+	name string
+	fn   TxnCheckFn
+}
+
+// AddTxnCheck appends a step to the txn admission, the steps run in the order they are added.
+// The built-in steps enabled in PoaConfig.TxnCheck come first.
+func (h *Poa) AddTxnCheck(name string, fn TxnCheckFn) {
+	h.txnChecks = append(h.txnChecks, txnCheck{name: name, fn: fn})
+}
+
+func (h *Poa) CheckTxn(txn *types.SignedTxn) error {
+	admitted := false
+	for _, check := range h.txnChecks {
+		err := check.fn(txn)
+		if err != nil {
+			if admitted {
+				h.releaseNonce(txn)
+			}
+			return rejectTxn(txn, check.name, err)
+		}
+		admitted = admitted || check.name == NonceCheck
+	}
+	return nil
+}
+
+func rejectTxn(txn *types.SignedTxn, check string, err error) error {
+	var rejected ErrTxnRejected
+	if errors.As(err, &rejected) {
+		return err
+	}
+	return TxnRejected(txn.TxnHash, check, err.Error())
+}
+
+func (h *Poa) initTxnChecks() {
+	if h.cfg.TxnCheck.Payload {
+		h.AddTxnCheck(PayloadCheck, h.checkPayload)
+	}
+	if h.cfg.TxnCheck.Signature {
+		h.AddTxnCheck(SignatureCheck, checkSignature)
+	}
+	if h.cfg.TxnCheck.Nonce {
+		h.AddTxnCheck(NonceCheck, h.checkNonce)
+	}
+}
+
+// verifyBlockTxns runs the built-in checks on the txns packed by the leader.
+// The nonces are checked against the committed ones only, since the pending txns of the local pool do not matter.
+func (h *Poa) verifyBlockTxns(block *types.Block) error {
+	// key: sender, value: next nonce
+	used := make(map[common.Address]uint64)
+	for _, txn := range block.Txns {
+		if h.cfg.TxnCheck.Payload {
+			if err := h.checkPayload(txn); err != nil {
+				return rejectTxn(txn, PayloadCheck, err)
+			}
+		}
+		if h.cfg.TxnCheck.Signature {
+			if err := checkSignature(txn); err != nil {
+				return rejectTxn(txn, SignatureCheck, err)
+			}
+		}
+		if !h.cfg.TxnCheck.Nonce {
+			continue
+		}
+		sender, nonce, err := senderNonce(txn)
+		if err != nil {
+			return rejectTxn(txn, NonceCheck, err)
+		}
+		next, ok := used[sender]
+		if !ok {
+			next, err = h.nextNonce(sender)
+			if err != nil {
+				return err
+			}
+		}
+		if nonce < next {
+			return rejectTxn(txn, NonceCheck, errors.Errorf("nonce(%d) of %s is used", nonce, sender.String()))
+		}
+		used[sender] = nonce + 1
+	}
+	return nil
+}
+
+// checkPayload rejects the oversized txns and the ones missing the call.
+func (h *Poa) checkPayload(txn *types.SignedTxn) error {
+	if txn.Raw == nil || txn.Raw.WrCall == nil {
+		return errors.New("txn has no call")
+	}
+	wrCall := txn.Raw.WrCall
+	if wrCall.TripodName == "" || wrCall.FuncName == "" {
+		return errors.New("txn calls no writing")
+	}
+	if !utf8.ValidString(wrCall.Params) {
+		return errors.New("params are not utf-8")
+	}
+	byt, err := txn.Encode()
+	if err != nil {
+		return err
+	}
+	if len(byt) > h.cfg.TxnCheck.MaxTxnSize {
+		return errors.Errorf("txn size(%d) is larger than %d", len(byt), h.cfg.TxnCheck.MaxTxnSize)
+	}
+	return nil
+}
+
+// isEthPubkey tells if the pubkey is an uncompressed secp256k1 key used by ethereum wallets,
+// which has no key type byte ahead.
+func isEthPubkey(pubkey []byte) bool {
+	return len(pubkey) == 65 && pubkey[0] == 4
+}
+
+// checkSignature verifies the signature by the key type of txn.
+// The ethereum wallets sign the call as personal message, the others sign the hash of the call.
+func checkSignature(txn *types.SignedTxn) error {
+	if len(txn.Pubkey) == 0 {
+		return errors.New("txn has no pubkey")
+	}
+	if len(txn.Signature) == 0 {
+		return errors.New("txn has no signature")
+	}
+	if txn.Raw == nil || txn.Raw.WrCall == nil {
+		return errors.New("txn has no call")
+	}
+	if isEthPubkey(txn.Pubkey) {
+		return checkEthSignature(txn)
+	}
+	pubkey, err := keypair.PubKeyFromBytes(txn.Pubkey)
+	if err != nil {
+		return err
+	}
+	if pubkey == nil {
+		return errors.New("secret-free key cannot sign txn")
+	}
+	hash, err := txn.Raw.WrCall.Hash()
+	if err != nil {
+		return err
+	}
+	if !pubkey.VerifySignature(hash, txn.Signature) {
+		return errors.Errorf("%s signature mismatches pubkey(%s)", pubkey.Type(), common.ToHex(txn.Pubkey))
+	}
+	return nil
+}
+
+func checkEthSignature(txn *types.SignedTxn) error {
+	if len(txn.Signature) != crypto.SignatureLength {
+		return errors.Errorf("ethereum signature length(%d) should be %d", len(txn.Signature), crypto.SignatureLength)
+	}
+	msg, err := json.Marshal(txn.Raw.WrCall)
+	if err != nil {
+		return err
+	}
+	sig := make([]byte, crypto.SignatureLength)
+	copy(sig, txn.Signature)
+	// wallets set V as 27 or 28
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	recovered, err := crypto.Ecrecover(metamask.MetamaskMsgHash(msg), sig)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(recovered, txn.Pubkey) {
+		return errors.Errorf("ethereum signature mismatches pubkey(%s)", common.ToHex(txn.Pubkey))
+	}
+	return nil
+}
+
+// TxnSender returns the address of the txn signer.
+func TxnSender(txn *types.SignedTxn) (common.Address, error) {
+	if isEthPubkey(txn.Pubkey) {
+		return *txn.GetEthFormatCaller(), nil
+	}
+	return callerAddress(txn)
+}
+
+// TxnNonce reads the nonce from the json params of txn, such as {"nonce": 1, ...}.
+// The nonce must be in params because the nonce field of the raw txn is not sent over the network.
+func TxnNonce(txn *types.SignedTxn) (uint64, error) {
+	var params struct {
+		Nonce *uint64 `json:"nonce"`
+	}
+	err := json.Unmarshal([]byte(txn.GetParams()), &params)
+	if err != nil {
+		return 0, errors.Wrap(err, "params are not json")
+	}
+	if params.Nonce == nil {
+		return 0, errors.New("params have no nonce")
+	}
+	return *params.Nonce, nil
+}
+
+func senderNonce(txn *types.SignedTxn) (common.Address, uint64, error) {
+	sender, err := TxnSender(txn)
+	if err != nil {
+		return common.Address{}, 0, err
+	}
+	nonce, err := TxnNonce(txn)
+	return sender, nonce, err
+}
+
+// nonceTracker remembers the nonces of the txns admitted into the pool but not committed yet,
+// and the next nonces of the senders in the blocks committed but not finalized yet.
+type nonceTracker struct {
+	sync.Mutex
+	// key: sender, nonce
+	pending map[common.Address]map[uint64]pendingTxn
+	// key: sender
+	committed map[common.Address]committedNonce
+}
+
+type pendingTxn struct {
+	hash common.Hash
+	tips uint64
+}
+
+type committedNonce struct {
+	next   uint64
+	height common.BlockNum
+}
+
+func newNonceTracker() *nonceTracker {
+	return &nonceTracker{
+		pending:   make(map[common.Address]map[uint64]pendingTxn),
+		committed: make(map[common.Address]committedNonce),
+	}
+}
+
+// admit takes the nonce of sender for the txn, it returns false if another pending txn has taken the nonce
+// with no lower tips. A txn with higher tips takes the nonce over, the replaced one is returned to leave the pool.
+func (nt *nonceTracker) admit(sender common.Address, nonce uint64, txnHash common.Hash, tips uint64) (common.Hash, bool) {
+	nt.Lock()
+	defer nt.Unlock()
+	nonces, ok := nt.pending[sender]
+	if !ok {
+		nonces = make(map[uint64]pendingTxn)
+		nt.pending[sender] = nonces
+	}
+	var replaced common.Hash
+	if holder, ok := nonces[nonce]; ok && holder.hash != txnHash {
+		if tips <= holder.tips {
+			return common.Hash{}, false
+		}
+		replaced = holder.hash
+	}
+	nonces[nonce] = pendingTxn{hash: txnHash, tips: tips}
+	return replaced, true
+}
+
+// release frees the nonce of sender if the txn still takes it.
+func (nt *nonceTracker) release(sender common.Address, nonce uint64, txnHash common.Hash) {
+	nt.Lock()
+	defer nt.Unlock()
+	if holder, ok := nt.pending[sender][nonce]; ok && holder.hash == txnHash {
+		delete(nt.pending[sender], nonce)
+	}
+	if len(nt.pending[sender]) == 0 {
+		delete(nt.pending, sender)
+	}
+}
+
+// commit forgets the pending nonces below next, and keeps next until the block of height is finalized.
+func (nt *nonceTracker) commit(sender common.Address, next uint64, height common.BlockNum) {
+	nt.Lock()
+	defer nt.Unlock()
+	for n := range nt.pending[sender] {
+		if n < next {
+			delete(nt.pending[sender], n)
+		}
+	}
+	if len(nt.pending[sender]) == 0 {
+		delete(nt.pending, sender)
+	}
+	nt.committed[sender] = committedNonce{next: next, height: height}
+}
+
+// finalize forgets the next nonces committed up to height, the finalized state has them.
+func (nt *nonceTracker) finalize(height common.BlockNum) {
+	nt.Lock()
+	defer nt.Unlock()
+	for sender, committed := range nt.committed {
+		if committed.height <= height {
+			delete(nt.committed, sender)
+		}
+	}
+}
+
+func (nt *nonceTracker) committedNext(sender common.Address) (uint64, bool) {
+	nt.Lock()
+	defer nt.Unlock()
+	committed, ok := nt.committed[sender]
+	return committed.next, ok
+}
+
+// checkNonce rejects the txn whose nonce is used by the committed txns of its sender,
+// or is taken by another pending txn with no lower tips.
+func (h *Poa) checkNonce(txn *types.SignedTxn) error {
+	sender, nonce, err := senderNonce(txn)
+	if err != nil {
+		return err
+	}
+	next, err := h.nextNonce(sender)
+	if err != nil {
+		return err
+	}
+	if nonce < next {
+		return errors.Errorf("nonce(%d) of %s is used, the next is %d", nonce, sender.String(), next)
+	}
+	replaced, ok := h.nonces.admit(sender, nonce, txn.TxnHash, txn.GetTips())
+	if !ok {
+		return errors.Errorf("nonce(%d) of %s is taken by another pending txn", nonce, sender.String())
+	}
+	if replaced != (common.Hash{}) {
+		return h.Pool.ResetByHashes([]common.Hash{replaced})
+	}
+	return nil
+}
+
+// releaseNonce frees the nonce taken by txn, when txn is rejected by a later check and does not enter the pool.
+func (h *Poa) releaseNonce(txn *types.SignedTxn) {
+	if h.Pool.Exist(txn.TxnHash) {
+		return
+	}
+	sender, nonce, err := senderNonce(txn)
+	if err != nil {
+		return
+	}
+	h.nonces.release(sender, nonce, txn.TxnHash)
+}
+
+func nonceKey(sender common.Address) []byte {
+	return append(append([]byte{}, noncePrefix...), sender.Bytes()...)
+}
+
+// nextNonce returns the smallest nonce of sender unused by the committed blocks, 0 if sender has none.
+// It is called by the txpool out of the block execution, so it reads the finalized state
+// and the nonces committed after it, instead of the state in execution.
+func (h *Poa) nextNonce(sender common.Address) (uint64, error) {
+	if next, ok := h.nonces.committedNext(sender); ok {
+		return next, nil
+	}
+	byt, err := h.GetFinalized(nonceKey(sender))
+	if err != nil || byt == nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(byt), nil
+}
+
+// commitNonces records the next nonces of the senders in the committed block into the state.
+func (h *Poa) commitNonces(block *types.Block) error {
+	for _, txn := range block.Txns {
+		sender, nonce, err := senderNonce(txn)
+		if err != nil {
+			// the txns without nonce are admitted when the nonce check is switched off.
+			continue
+		}
+		next, err := h.nextNonce(sender)
+		if err != nil {
+			return err
+		}
+		if nonce < next {
+			continue
+		}
+		byt := make([]byte, 8)
+		binary.BigEndian.PutUint64(byt, nonce+1)
+		h.Set(nonceKey(sender), byt)
+		h.nonces.commit(sender, nonce+1, block.Height)
+	}
+	return nil
+}
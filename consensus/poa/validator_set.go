@@ -0,0 +1,580 @@
+package poa
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/context"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+const (
+	AddValidatorOp    = "add"
+	RemoveValidatorOp = "remove"
+)
+
+// MaxValidatorWeight bounds the length of the leader schedule.
+const MaxValidatorWeight = 1000
+
+var (
+	validatorChangesKey   = []byte("validator_changes")
+	validatorProposalsKey = []byte("validator_proposals")
+)
+
+// ValidatorSet is the ordered validators which work from StartHeight.
+type ValidatorSet struct {
+	StartHeight common.BlockNum
+	// the order of leader schedule
+	Addrs []common.Address
+	Infos map[common.Address]ValidatorInfo
+	// indexes of Addrs, one cycle of the weighted leader schedule
+	slots []int
+}
+
+func NewValidatorSet(startHeight common.BlockNum, infos []ValidatorInfo) *ValidatorSet {
+	set := &ValidatorSet{
+		StartHeight: startHeight,
+		Addrs:       make([]common.Address, 0, len(infos)),
+		Infos:       make(map[common.Address]ValidatorInfo),
+	}
+	for _, info := range infos {
+		addr := info.Pubkey.Address()
+		if _, ok := set.Infos[addr]; ok {
+			continue
+		}
+		set.Addrs = append(set.Addrs, addr)
+		set.Infos[addr] = info
+	}
+	set.slots = weightedSlots(set.weights())
+	return set
+}
+
+func (s *ValidatorSet) weights() []uint64 {
+	weights := make([]uint64, 0, s.Len())
+	for _, addr := range s.Addrs {
+		weight := s.Infos[addr].Weight
+		if weight == 0 {
+			weight = 1
+		}
+		weights = append(weights, weight)
+	}
+	return weights
+}
+
+// weightedSlots interleaves the validators by their weights with the smooth weighted round-robin,
+// every validator gets slots as many as its weight in one cycle, spread as evenly as possible.
+// With the same weights, it is the plain round-robin.
+func weightedSlots(weights []uint64) []int {
+	divisor := uint64(0)
+	for _, weight := range weights {
+		divisor = gcd(divisor, weight)
+	}
+	var total int64
+	for i := range weights {
+		weights[i] /= divisor
+		total += int64(weights[i])
+	}
+
+	slots := make([]int, 0, total)
+	current := make([]int64, len(weights))
+	for len(slots) < int(total) {
+		best := 0
+		for i, weight := range weights {
+			current[i] += int64(weight)
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		slots = append(slots, best)
+	}
+	return slots
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// Proposer returns the validator scheduled for height in the first round.
+func (s *ValidatorSet) Proposer(height common.BlockNum) common.Address {
+	return s.Addrs[s.slots[(uint64(height)-1)%uint64(len(s.slots))]]
+}
+
+// ProposerBySeed draws a validator by the random seed, the chance of a validator is in proportion to its weight.
+func (s *ValidatorSet) ProposerBySeed(seed []byte) common.Address {
+	n := binary.BigEndian.Uint64(common.Sha256(seed)[:8])
+	return s.Addrs[s.slots[n%uint64(len(s.slots))]]
+}
+
+// Slots returns one cycle of the leader schedule, which repeats from height 1.
+func (s *ValidatorSet) Slots() []common.Address {
+	addrs := make([]common.Address, 0, len(s.slots))
+	for _, idx := range s.slots {
+		addrs = append(addrs, s.Addrs[idx])
+	}
+	return addrs
+}
+
+func (s *ValidatorSet) Len() int {
+	return len(s.Addrs)
+}
+
+func (s *ValidatorSet) Contains(addr common.Address) bool {
+	_, ok := s.Infos[addr]
+	return ok
+}
+
+// Index returns the position of addr in the set, -1 if it is not a validator.
+func (s *ValidatorSet) Index(addr common.Address) int {
+	for i, a := range s.Addrs {
+		if a == addr {
+			return i
+		}
+	}
+	return -1
+}
+
+// Exclude returns the set without addrs, in the same order. s itself is returned
+// if none of addrs is in it, or all of it is.
+func (s *ValidatorSet) Exclude(addrs []common.Address) *ValidatorSet {
+	excluded := make(map[common.Address]struct{}, len(addrs))
+	for _, addr := range addrs {
+		excluded[addr] = struct{}{}
+	}
+	infos := make([]ValidatorInfo, 0, s.Len())
+	for _, addr := range s.Addrs {
+		if _, ok := excluded[addr]; !ok {
+			infos = append(infos, s.Infos[addr])
+		}
+	}
+	if len(infos) == s.Len() || len(infos) == 0 {
+		return s
+	}
+	return NewValidatorSet(s.StartHeight, infos)
+}
+
+func (s *ValidatorSet) P2pIDs() (peers []peer.ID) {
+	for _, addr := range s.Addrs {
+		if id := s.Infos[addr].P2pID; id != "" {
+			peers = append(peers, id)
+		}
+	}
+	return
+}
+
+func (s *ValidatorSet) apply(startHeight common.BlockNum, change *ValidatorChange) (*ValidatorSet, error) {
+	info, err := change.validatorInfo()
+	if err != nil {
+		return nil, err
+	}
+	addr := info.Pubkey.Address()
+
+	infos := make([]ValidatorInfo, 0, s.Len()+1)
+	for _, a := range s.Addrs {
+		infos = append(infos, s.Infos[a])
+	}
+
+	switch change.Op {
+	case AddValidatorOp:
+		if s.Contains(addr) {
+			return nil, errors.Errorf("validator(%s) already exists", addr.String())
+		}
+		infos = append(infos, info)
+	case RemoveValidatorOp:
+		idx := s.Index(addr)
+		if idx < 0 {
+			return nil, errors.Errorf("validator(%s) not found", addr.String())
+		}
+		if s.Len() == 1 {
+			return nil, errors.New("cannot remove the last validator")
+		}
+		infos = append(infos[:idx], infos[idx+1:]...)
+	default:
+		return nil, errors.Errorf("unknown validator change op(%s)", change.Op)
+	}
+	return NewValidatorSet(startHeight, infos), nil
+}
+
+// ValidatorChange adds or removes one validator from Height on.
+// It is recorded on chain, so that all nodes switch at the same height.
+type ValidatorChange struct {
+	Height common.BlockNum `json:"height"`
+	Op     string          `json:"op"`
+	Pubkey string          `json:"pubkey"`
+	P2pID  string          `json:"p2p_id,omitempty"`
+	Weight uint64          `json:"weight,omitempty"`
+}
+
+func (c *ValidatorChange) validatorInfo() (ValidatorInfo, error) {
+	pubkey, err := keypair.PubkeyFromStr(c.Pubkey)
+	if err != nil {
+		return ValidatorInfo{}, err
+	}
+	if pubkey == nil {
+		return ValidatorInfo{}, errors.Errorf("illegal pubkey(%s)", c.Pubkey)
+	}
+	if c.Weight > MaxValidatorWeight {
+		return ValidatorInfo{}, errors.Errorf("weight(%d) is larger than %d", c.Weight, MaxValidatorWeight)
+	}
+	info := ValidatorInfo{Pubkey: pubkey, Weight: c.Weight}
+	if c.P2pID != "" {
+		info.P2pID, err = peer.Decode(c.P2pID)
+		if err != nil {
+			return ValidatorInfo{}, err
+		}
+	}
+	return info, nil
+}
+
+// validatorSchedule keeps all the validator sets by their start heights.
+type validatorSchedule struct {
+	sync.RWMutex
+	genesis *ValidatorSet
+	// ascending by StartHeight
+	sets []*ValidatorSet
+}
+
+func newValidatorSchedule(genesis *ValidatorSet) *validatorSchedule {
+	return &validatorSchedule{
+		genesis: genesis,
+		sets:    []*ValidatorSet{genesis},
+	}
+}
+
+func (vs *validatorSchedule) at(height common.BlockNum) *ValidatorSet {
+	vs.RLock()
+	defer vs.RUnlock()
+	for i := len(vs.sets) - 1; i > 0; i-- {
+		if vs.sets[i].StartHeight <= height {
+			return vs.sets[i]
+		}
+	}
+	return vs.sets[0]
+}
+
+func (vs *validatorSchedule) all() []*ValidatorSet {
+	vs.RLock()
+	defer vs.RUnlock()
+	return vs.sets
+}
+
+func (vs *validatorSchedule) rebuild(changes []*ValidatorChange) error {
+	sets, err := buildValidatorSets(vs.genesis, changes)
+	if err != nil {
+		return err
+	}
+	vs.Lock()
+	vs.sets = sets
+	vs.Unlock()
+	return nil
+}
+
+func buildValidatorSets(genesis *ValidatorSet, changes []*ValidatorChange) ([]*ValidatorSet, error) {
+	sorted := make([]*ValidatorChange, len(changes))
+	copy(sorted, changes)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Height < sorted[j].Height
+	})
+
+	sets := []*ValidatorSet{genesis}
+	for _, change := range sorted {
+		last := sets[len(sets)-1]
+		set, err := last.apply(change.Height, change)
+		if err != nil {
+			return nil, err
+		}
+		if len(sets) > 1 && last.StartHeight == change.Height {
+			sets[len(sets)-1] = set
+		} else {
+			sets = append(sets, set)
+		}
+	}
+	return sets, nil
+}
+
+// ValidatorProposal is a validator change voted by the validators, it is applied
+// once more than 2/3 validators vote for it before its height.
+type ValidatorProposal struct {
+	Change *ValidatorChange `json:"change"`
+	Voters []common.Address `json:"voters"`
+}
+
+func (p *ValidatorProposal) votedBy(addr common.Address) bool {
+	for _, voter := range p.Voters {
+		if voter == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// votes counts the voters who are still in set.
+func (p *ValidatorProposal) votes(set *ValidatorSet) int {
+	votes := 0
+	for _, voter := range p.Voters {
+		if set.Contains(voter) {
+			votes++
+		}
+	}
+	return votes
+}
+
+// AddValidator votes for adding a validator from the given height on.
+// Every validator sends the same params, the change is applied with the votes of more than 2/3 validators.
+// params: {"height": 100, "pubkey": "0x...", "p2p_id": "12D3KooW...", "weight": 1}
+func (h *Poa) AddValidator(ctx *context.WriteContext) error {
+	return h.changeValidator(ctx, AddValidatorOp)
+}
+
+// RemoveValidator votes for removing a validator from the given height on, like AddValidator.
+// params: {"height": 100, "pubkey": "0x..."}
+func (h *Poa) RemoveValidator(ctx *context.WriteContext) error {
+	return h.changeValidator(ctx, RemoveValidatorOp)
+}
+
+func (h *Poa) changeValidator(ctx *context.WriteContext, op string) error {
+	ctx.SetLei(1)
+	change := new(ValidatorChange)
+	err := ctx.BindJson(change)
+	if err != nil {
+		return err
+	}
+	change.Op = op
+	pubkey, err := keypair.PubkeyFromStr(change.Pubkey)
+	if err != nil {
+		return err
+	}
+	if pubkey == nil {
+		return errors.Errorf("illegal pubkey(%s)", change.Pubkey)
+	}
+	// the votes of a change are matched by its params
+	change.Pubkey = pubkey.StringWithType()
+	if op == AddValidatorOp {
+		peerID, err := resolvePeerID(pubkey, change.P2pID, h.cfg.KeyPeerID)
+		if err != nil {
+			return err
+		}
+		if peerID != "" {
+			change.P2pID = peerID.String()
+		}
+	}
+
+	caller, err := callerAddress(ctx.Txn)
+	if err != nil {
+		return err
+	}
+	set := h.ValidatorSetAt(ctx.Block.Height)
+	if !set.Contains(caller) {
+		return errors.Errorf("caller(%s) is not validator", caller.String())
+	}
+	if change.Height <= ctx.Block.Height {
+		return errors.Errorf("validator change height(%d) must be after current height(%d)", change.Height, ctx.Block.Height)
+	}
+	if !h.isEpochStart(change.Height) {
+		return errors.Errorf("validator change height(%d) must start an epoch of %d blocks", change.Height, h.cfg.EpochLength)
+	}
+
+	changes, err := h.loadValidatorChanges()
+	if err != nil {
+		return err
+	}
+	for _, applied := range changes {
+		if *applied == *change {
+			return errors.Errorf("validator(%s) change(%s) at height(%d) is applied already", change.Pubkey, op, change.Height)
+		}
+	}
+	// make sure the new schedule is still valid
+	changes = append(changes, change)
+	_, err = buildValidatorSets(h.validators.genesis, changes)
+	if err != nil {
+		return err
+	}
+
+	proposals, err := h.loadValidatorProposals()
+	if err != nil {
+		return err
+	}
+	var proposal *ValidatorProposal
+	// the proposals whose height has come can never be applied
+	pending := make([]*ValidatorProposal, 0, len(proposals)+1)
+	for _, p := range proposals {
+		switch {
+		case p.Change.Height <= ctx.Block.Height:
+		case *p.Change == *change:
+			proposal = p
+		default:
+			pending = append(pending, p)
+		}
+	}
+	if proposal == nil {
+		proposal = &ValidatorProposal{Change: change}
+	}
+	if proposal.votedBy(caller) {
+		return errors.Errorf("caller(%s) voted for validator(%s) change(%s) already", caller.String(), change.Pubkey, op)
+	}
+	proposal.Voters = append(proposal.Voters, caller)
+
+	votes := proposal.votes(set)
+	if votes < quorum(set.Len()) {
+		err = h.storeValidatorProposals(append(pending, proposal))
+		if err != nil {
+			return err
+		}
+		logrus.Infof("validator(%s) change(%s) at height(%d) has %d of %d votes",
+			change.Pubkey, op, change.Height, votes, quorum(set.Len()))
+		return ctx.EmitJsonEvent(proposal)
+	}
+
+	err = h.storeValidatorProposals(pending)
+	if err != nil {
+		return err
+	}
+	err = h.storeValidatorChanges(changes)
+	if err != nil {
+		return err
+	}
+	h.validatorsChanged = true
+
+	logrus.Infof("validator(%s) change(%s) takes effect at height(%d)", change.Pubkey, op, change.Height)
+	return ctx.EmitJsonEvent(proposal)
+}
+
+// GetValidatorProposals returns the validator changes waiting for votes, by the committed state.
+// The ones whose height has come are left out, they are dropped from the state by the next vote.
+func (h *Poa) GetValidatorProposals() ([]*ValidatorProposal, error) {
+	end, err := h.Chain.GetEndCompactBlock()
+	if err != nil {
+		return nil, err
+	}
+	proposals, err := decodeValidatorProposals(h.GetFinalized(validatorProposalsKey))
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]*ValidatorProposal, 0, len(proposals))
+	for _, proposal := range proposals {
+		if proposal.Change.Height > end.Height+1 {
+			pending = append(pending, proposal)
+		}
+	}
+	return pending, nil
+}
+
+func (h *Poa) QueryValidatorProposals(ctx *context.ReadContext) {
+	proposals, err := h.GetValidatorProposals()
+	if err != nil {
+		ctx.Err(http.StatusInternalServerError, err)
+		return
+	}
+	ctx.JsonOk(proposals)
+}
+
+// Commit refreshes the validator schedule if it changes in this block,
+// and records the nonces of its txns and the slots of its leaders.
+func (h *Poa) Commit(block *types.Block) {
+	if h.cfg.TxnCheck.Nonce {
+		err := h.commitNonces(block)
+		if err != nil {
+			logrus.Errorf("commit txn nonces on block(%d) failed: %v", block.Height, err)
+		}
+	}
+	err := h.commitLiveness(block)
+	if err != nil {
+		logrus.Errorf("commit liveness on block(%d) failed: %v", block.Height, err)
+	}
+	if h.jailsChanged {
+		h.jailsChanged = false
+		err = h.reloadJails()
+		if err != nil {
+			logrus.Errorf("reload jailed validators on block(%d) failed: %v", block.Height, err)
+		}
+	}
+	if !h.validatorsChanged {
+		return
+	}
+	h.validatorsChanged = false
+	err = h.reloadValidators()
+	if err != nil {
+		logrus.Errorf("reload validators on block(%d) failed: %v", block.Height, err)
+	}
+}
+
+// ValidatorSetAt returns the validators who take effect on height.
+func (h *Poa) ValidatorSetAt(height common.BlockNum) *ValidatorSet {
+	return h.validators.at(height)
+}
+
+func (h *Poa) loadValidatorChanges() ([]*ValidatorChange, error) {
+	byt, err := h.Get(validatorChangesKey)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]*ValidatorChange, 0)
+	if byt == nil {
+		return changes, nil
+	}
+	err = json.Unmarshal(byt, &changes)
+	return changes, err
+}
+
+func (h *Poa) storeValidatorChanges(changes []*ValidatorChange) error {
+	byt, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	h.Set(validatorChangesKey, byt)
+	return nil
+}
+
+func (h *Poa) loadValidatorProposals() ([]*ValidatorProposal, error) {
+	return decodeValidatorProposals(h.Get(validatorProposalsKey))
+}
+
+func decodeValidatorProposals(byt []byte, err error) ([]*ValidatorProposal, error) {
+	if err != nil {
+		return nil, err
+	}
+	proposals := make([]*ValidatorProposal, 0)
+	if byt == nil {
+		return proposals, nil
+	}
+	err = json.Unmarshal(byt, &proposals)
+	return proposals, err
+}
+
+func (h *Poa) storeValidatorProposals(proposals []*ValidatorProposal) error {
+	byt, err := json.Marshal(proposals)
+	if err != nil {
+		return err
+	}
+	h.Set(validatorProposalsKey, byt)
+	return nil
+}
+
+func (h *Poa) reloadValidators() error {
+	changes, err := h.loadValidatorChanges()
+	if err != nil {
+		return err
+	}
+	return h.validators.rebuild(changes)
+}
+
+func callerAddress(txn *types.SignedTxn) (common.Address, error) {
+	pubkey, err := keypair.PubKeyFromBytes(txn.Pubkey)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if pubkey == nil {
+		return common.Address{}, errors.New("txn has no pubkey")
+	}
+	return pubkey.Address(), nil
+}
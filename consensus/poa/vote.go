@@ -0,0 +1,463 @@
+package poa
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/cockroachdb/pebble"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/yu-org/yu/common"
+	"github.com/yu-org/yu/core/context"
+	"github.com/yu-org/yu/core/keypair"
+	"github.com/yu-org/yu/core/types"
+	"github.com/yu-org/yu/utils/log"
+	"net/http"
+	"sync"
+)
+
+const VoteTopic = "poa-vote"
+
+var finalityCertPrefix = []byte("finality_cert_")
+
+// the votes for heights further than it ahead of the local one are dropped,
+// a validator cannot fill the memory with them. The blocks so far ahead are finalized by catching up.
+const voteHeightsAhead = 64
+
+// Vote is signed by a validator after it executes the block,
+// with the state root and receipt root it computes.
+type Vote struct {
+	Height      common.BlockNum `json:"height"`
+	BlockHash   common.Hash     `json:"block_hash"`
+	StateRoot   common.Hash     `json:"state_root"`
+	ReceiptRoot common.Hash     `json:"receipt_root"`
+	// the hash of the Checkpoint, only on the last block of an epoch
+	Checkpoint *common.Hash `json:"checkpoint,omitempty"`
+	Pubkey     []byte       `json:"pubkey"`
+	Signature  []byte       `json:"signature"`
+}
+
+func (v *Vote) SignHash() []byte {
+	byt, _ := json.Marshal(struct {
+		Height      common.BlockNum `json:"height"`
+		BlockHash   common.Hash     `json:"block_hash"`
+		StateRoot   common.Hash     `json:"state_root"`
+		ReceiptRoot common.Hash     `json:"receipt_root"`
+		Checkpoint  *common.Hash    `json:"checkpoint,omitempty"`
+	}{v.Height, v.BlockHash, v.StateRoot, v.ReceiptRoot, v.Checkpoint})
+	return common.Sha256(byt)
+}
+
+func (v *Vote) target() voteTarget {
+	return voteTarget{BlockHash: v.BlockHash, StateRoot: v.StateRoot, ReceiptRoot: v.ReceiptRoot}
+}
+
+func (v *Vote) Encode() ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func DecodeVote(byt []byte) (*Vote, error) {
+	v := new(Vote)
+	err := json.Unmarshal(byt, v)
+	return v, err
+}
+
+// Voter verifies the signature of the vote and returns the address of its signer.
+func (v *Vote) Voter() (common.Address, error) {
+	pubkey, err := keypair.PubKeyFromBytes(v.Pubkey)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if pubkey == nil {
+		return common.Address{}, errors.New("vote has no pubkey")
+	}
+	if !pubkey.VerifySignature(v.SignHash(), v.Signature) {
+		return common.Address{}, errors.Errorf("vote signature from %s is illegal", pubkey.Address().String())
+	}
+	return pubkey.Address(), nil
+}
+
+// FinalityCert proves that more than 2/3 validators have signed the block and its execution result.
+type FinalityCert struct {
+	Height      common.BlockNum `json:"height"`
+	BlockHash   common.Hash     `json:"block_hash"`
+	StateRoot   common.Hash     `json:"state_root"`
+	ReceiptRoot common.Hash     `json:"receipt_root"`
+	Votes       []*Vote         `json:"votes"`
+}
+
+func (c *FinalityCert) Encode() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+func DecodeFinalityCert(byt []byte) (*FinalityCert, error) {
+	c := new(FinalityCert)
+	err := json.Unmarshal(byt, c)
+	return c, err
+}
+
+// Verify checks the certificate against the validators of its height.
+func (c *FinalityCert) Verify(validators *ValidatorSet) error {
+	signed := make(map[common.Address]struct{})
+	for _, vote := range c.Votes {
+		if vote.Height != c.Height || vote.target() != c.target() {
+			return errors.Errorf("vote for block(%s) height(%d) mismatches the certificate", vote.BlockHash.String(), vote.Height)
+		}
+		voter, err := vote.Voter()
+		if err != nil {
+			return err
+		}
+		if !validators.Contains(voter) {
+			return errors.Errorf("voter(%s) is not validator", voter.String())
+		}
+		signed[voter] = struct{}{}
+	}
+	if len(signed) < quorum(validators.Len()) {
+		return errors.Errorf("finality certificate of block(%s) only has %d votes, needs %d",
+			c.BlockHash.String(), len(signed), quorum(validators.Len()))
+	}
+	return nil
+}
+
+func (c *FinalityCert) target() voteTarget {
+	return voteTarget{BlockHash: c.BlockHash, StateRoot: c.StateRoot, ReceiptRoot: c.ReceiptRoot}
+}
+
+// quorum is the number of validators more than 2/3.
+func quorum(n int) int {
+	return n*2/3 + 1
+}
+
+// voteTarget is what validators agree on, the block and its execution result.
+type voteTarget struct {
+	BlockHash   common.Hash
+	StateRoot   common.Hash
+	ReceiptRoot common.Hash
+}
+
+func headerTarget(header *types.Header) voteTarget {
+	return voteTarget{BlockHash: header.Hash, StateRoot: header.StateRoot, ReceiptRoot: header.ReceiptRoot}
+}
+
+// voteCollector gathers the votes for the executed blocks until they are finalized.
+type voteCollector struct {
+	sync.Mutex
+	// key: height, vote target, voter
+	votes map[common.BlockNum]map[voteTarget]map[common.Address]*Vote
+	// executed blocks waiting for finality
+	blocks        map[common.BlockNum]*types.Block
+	lastFinalized common.BlockNum
+	started       bool
+	// signaled when a new vote is added, the kernel finalizes the blocks reaching the quorum then
+	addedCh chan struct{}
+}
+
+func newVoteCollector() *voteCollector {
+	return &voteCollector{
+		votes:   make(map[common.BlockNum]map[voteTarget]map[common.Address]*Vote),
+		blocks:  make(map[common.BlockNum]*types.Block),
+		addedCh: make(chan struct{}, 1),
+	}
+}
+
+// addVote drops the vote for a finalized height. A voter has one vote on a height,
+// its votes for other blocks or roots on the height are dropped.
+func (vc *voteCollector) addVote(voter common.Address, vote *Vote) {
+	vc.Lock()
+	defer vc.Unlock()
+	if vote.Height <= vc.lastFinalized {
+		return
+	}
+	targets, ok := vc.votes[vote.Height]
+	if !ok {
+		targets = make(map[voteTarget]map[common.Address]*Vote)
+		vc.votes[vote.Height] = targets
+	}
+	for _, voters := range targets {
+		if _, ok := voters[voter]; ok {
+			return
+		}
+	}
+	voters, ok := targets[vote.target()]
+	if !ok {
+		voters = make(map[common.Address]*Vote)
+		targets[vote.target()] = voters
+	}
+	voters[voter] = vote
+	select {
+	case vc.addedCh <- struct{}{}:
+	default:
+	}
+}
+
+// votesOf returns the votes for the block, whatever roots they have.
+func (vc *voteCollector) votesOf(height common.BlockNum, blockHash common.Hash) map[common.Address]*Vote {
+	vc.Lock()
+	defer vc.Unlock()
+	votes := make(map[common.Address]*Vote)
+	for target, voters := range vc.votes[height] {
+		if target.BlockHash != blockHash {
+			continue
+		}
+		for voter, vote := range voters {
+			votes[voter] = vote
+		}
+	}
+	return votes
+}
+
+// divergence returns the largest number of validators who vote for the same block
+// with the same roots, which are different from the local ones.
+func (vc *voteCollector) divergence(height common.BlockNum, local voteTarget) (most int) {
+	vc.Lock()
+	defer vc.Unlock()
+	for target, voters := range vc.votes[height] {
+		if target.BlockHash == local.BlockHash && target != local && len(voters) > most {
+			most = len(voters)
+		}
+	}
+	return
+}
+
+func (vc *voteCollector) addBlock(block *types.Block) {
+	vc.Lock()
+	defer vc.Unlock()
+	if !vc.started {
+		// blocks before the (re)start are not waited for.
+		vc.started = true
+		if block.Height > vc.lastFinalized+1 {
+			vc.lastFinalized = block.Height - 1
+		}
+	}
+	if block.Height <= vc.lastFinalized {
+		return
+	}
+	// only keep the header, the block may wait for a long time if validators are offline.
+	vc.blocks[block.Height] = &types.Block{Header: block.Header}
+}
+
+// ready returns the blocks which reach the quorum, in ascending height order.
+func (vc *voteCollector) ready(quorumAt func(common.BlockNum) int) (blocks []*types.Block, certs []*FinalityCert) {
+	vc.Lock()
+	defer vc.Unlock()
+	for {
+		height := vc.lastFinalized + 1
+		block, ok := vc.blocks[height]
+		if !ok {
+			return
+		}
+		voters := vc.votes[height][headerTarget(block.Header)]
+		if len(voters) < quorumAt(height) {
+			return
+		}
+		cert := &FinalityCert{
+			Height:      height,
+			BlockHash:   block.Hash,
+			StateRoot:   block.StateRoot,
+			ReceiptRoot: block.ReceiptRoot,
+		}
+		for _, vote := range voters {
+			cert.Votes = append(cert.Votes, vote)
+		}
+		blocks = append(blocks, block)
+		certs = append(certs, cert)
+
+		delete(vc.blocks, height)
+		delete(vc.votes, height)
+		vc.lastFinalized = height
+	}
+}
+
+// skipTo finalizes the height by a certificate from other validators,
+// and returns the waiting blocks below it, which are final as its ancestors.
+func (vc *voteCollector) skipTo(height common.BlockNum) (ancestors []*types.Block, ok bool) {
+	vc.Lock()
+	defer vc.Unlock()
+	vc.started = true
+	if height <= vc.lastFinalized {
+		return nil, false
+	}
+	for hei := vc.lastFinalized + 1; hei <= height; hei++ {
+		if block, exists := vc.blocks[hei]; exists && hei < height {
+			ancestors = append(ancestors, block)
+		}
+		delete(vc.blocks, hei)
+		delete(vc.votes, hei)
+	}
+	vc.lastFinalized = height
+	return ancestors, true
+}
+
+func (h *Poa) vote(block *types.Block) error {
+	if !h.ValidatorSetAt(block.Height).Contains(h.LocalAddress()) {
+		return nil
+	}
+	vote := &Vote{
+		Height:      block.Height,
+		BlockHash:   block.Hash,
+		StateRoot:   block.StateRoot,
+		ReceiptRoot: block.ReceiptRoot,
+		Pubkey:      h.myPubkey.BytesWithType(),
+	}
+	if h.IsEpochEnd(block.Height) {
+		hash := h.newCheckpoint(block.Height, block.Hash).Hash()
+		vote.Checkpoint = &hash
+	}
+	var err error
+	vote.Signature, err = h.signer.SignVote(vote)
+	if err != nil {
+		return err
+	}
+	h.votes.addVote(h.LocalAddress(), vote)
+
+	byt, err := vote.Encode()
+	if err != nil {
+		return err
+	}
+	return h.P2pNetwork.PubP2P(VoteTopic, byt)
+}
+
+func (h *Poa) handleVotes() {
+	for {
+		msg, err := h.P2pNetwork.SubP2P(VoteTopic)
+		if err != nil {
+			logrus.Error("subscribe vote from P2P error: ", err)
+			continue
+		}
+		if h.failed.Load() {
+			continue
+		}
+		vote, err := DecodeVote(msg)
+		if err != nil {
+			logrus.Error("decode vote from p2p error: ", err)
+			continue
+		}
+		err = h.acceptVote(vote)
+		if err != nil {
+			logrus.Warn("verify vote failed: ", err)
+		}
+	}
+}
+
+// acceptVote collects the vote of another validator, from p2p or from a sync response.
+// The blocks it finalizes are applied by the kernel in FinalizeBlock or StartBlock, since the state and chain
+// are not safe to finalize out of the kernel.
+func (h *Poa) acceptVote(vote *Vote) error {
+	voter, err := vote.Voter()
+	if err != nil {
+		return err
+	}
+	if voter == h.LocalAddress() {
+		return nil
+	}
+	if !h.ValidatorSetAt(vote.Height).Contains(voter) {
+		return errors.Errorf("voter(%s) is not validator on height(%d)", voter.String(), vote.Height)
+	}
+	// the votes far ahead still tell the local node is behind
+	h.observe(voter, vote.Height)
+	if current := h.getCurrentHeight(); vote.Height > current+voteHeightsAhead {
+		return errors.Errorf("vote of height(%d) is too far ahead of the local height(%d)", vote.Height, current)
+	}
+	h.votes.addVote(voter, vote)
+	h.checkVotedRoots(voter, vote)
+	return nil
+}
+
+// tryFinalize finalizes the blocks reaching the quorum, it is called by the kernel only.
+func (h *Poa) tryFinalize() {
+	h.finalizeLock.Lock()
+	defer h.finalizeLock.Unlock()
+	blocks, certs := h.votes.ready(func(height common.BlockNum) int {
+		return quorum(h.ValidatorSetAt(height).Len())
+	})
+	for i, block := range blocks {
+		err := h.storeFinalityCert(certs[i])
+		if err != nil {
+			logrus.Errorf("store finality certificate of block(%d) failed: %v", block.Height, err)
+		}
+		h.saveCheckpoint(certs[i])
+		h.finalize(block)
+	}
+}
+
+// finalizeWithCert finalizes the block by the certificate collected by other validators.
+func (h *Poa) finalizeWithCert(block *types.Block, cert *FinalityCert) {
+	h.finalizeLock.Lock()
+	defer h.finalizeLock.Unlock()
+	ancestors, ok := h.votes.skipTo(block.Height)
+	if !ok {
+		return
+	}
+	for _, ancestor := range ancestors {
+		h.finalize(ancestor)
+	}
+	err := h.storeFinalityCert(cert)
+	if err != nil {
+		logrus.Errorf("store finality certificate of block(%d) failed: %v", block.Height, err)
+	}
+	h.saveCheckpoint(cert)
+	h.finalize(&types.Block{Header: block.Header})
+}
+
+func (h *Poa) finalize(block *types.Block) {
+	if h.cfg.PrettyLog {
+		log.DoubleLineConsole.Info(fmt.Sprintf("finalize block, height=%d, hash=%s", block.Height, block.Hash.String()))
+	}
+	h.State.FinalizeBlock(block)
+	h.nonces.finalize(block.Height)
+	err := h.Chain.Finalize(block)
+	if err != nil {
+		logrus.Errorf("finalize block(%d) failed: %v", block.Height, err)
+	}
+}
+
+func finalityCertKey(height common.BlockNum) []byte {
+	key := make([]byte, len(finalityCertPrefix)+8)
+	copy(key, finalityCertPrefix)
+	binary.BigEndian.PutUint64(key[len(finalityCertPrefix):], uint64(height))
+	return key
+}
+
+func (h *Poa) storeFinalityCert(cert *FinalityCert) error {
+	byt, err := cert.Encode()
+	if err != nil {
+		return err
+	}
+	return h.db.Set(finalityCertKey(cert.Height), byt, pebble.Sync)
+}
+
+// GetFinalityCert returns the finality certificate of height, nil if the block is not finalized yet.
+func (h *Poa) GetFinalityCert(height common.BlockNum) (*FinalityCert, error) {
+	byt, closer, err := h.db.Get(finalityCertKey(height))
+	if err == pebble.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return DecodeFinalityCert(byt)
+}
+
+type HeightRequest struct {
+	Height common.BlockNum `json:"height"`
+}
+
+func (h *Poa) QueryFinalityCert(ctx *context.ReadContext) {
+	var req HeightRequest
+	err := ctx.BindJson(&req)
+	if err != nil {
+		ctx.Err(http.StatusBadRequest, err)
+		return
+	}
+	cert, err := h.GetFinalityCert(req.Height)
+	if err != nil {
+		ctx.ErrOk(err)
+		return
+	}
+	if cert == nil {
+		ctx.ErrOk(errors.Errorf("block of height(%d) is not finalized", req.Height))
+		return
+	}
+	ctx.JsonOk(cert)
+}
@@ -0,0 +1,184 @@
+// Package clock is the time source of the tripods. Production uses Wall,
+// tests use a Manual clock to move the time by hand instead of waiting for it.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Sleep(d time.Duration)
+	NewTimer(d time.Duration) Timer
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	// Stop returns false if the timer has fired or stopped already.
+	Stop() bool
+}
+
+// Wall is the clock of the real world.
+var Wall Clock = wallClock{}
+
+type wallClock struct{}
+
+func (wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func (wallClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (wallClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (wallClock) NewTimer(d time.Duration) Timer {
+	return wallTimer{timer: time.NewTimer(d)}
+}
+
+type wallTimer struct {
+	timer *time.Timer
+}
+
+func (t wallTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t wallTimer) Stop() bool {
+	return t.timer.Stop()
+}
+
+// Manual is a clock which stays still until Advance moves it.
+// Sleep and timers wait until the clock reaches their deadlines.
+type Manual struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*manualTimer
+}
+
+func NewManual(start time.Time) *Manual {
+	m := &Manual{now: start}
+	m.cond = sync.NewCond(&m.lock)
+	return m
+}
+
+func (m *Manual) Now() time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.now
+}
+
+func (m *Manual) Since(t time.Time) time.Duration {
+	return m.Now().Sub(t)
+}
+
+func (m *Manual) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	<-m.NewTimer(d).C()
+}
+
+func (m *Manual) NewTimer(d time.Duration) Timer {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	t := &manualTimer{
+		clock:    m,
+		deadline: m.now.Add(d),
+		ch:       make(chan time.Time, 1),
+	}
+	if d <= 0 {
+		t.ch <- m.now
+		return t
+	}
+	m.timers = append(m.timers, t)
+	m.cond.Broadcast()
+	return t
+}
+
+// Advance moves the clock forward by d, and fires the timers due by then in the order of their deadlines.
+func (m *Manual) Advance(d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.now = m.now.Add(d)
+	sort.SliceStable(m.timers, func(i, j int) bool {
+		return m.timers[i].deadline.Before(m.timers[j].deadline)
+	})
+	fired := 0
+	for _, t := range m.timers {
+		if t.deadline.After(m.now) {
+			break
+		}
+		t.ch <- m.now
+		fired++
+	}
+	m.timers = m.timers[fired:]
+	m.cond.Broadcast()
+}
+
+// Waiters returns the number of the timers and sleeps waiting on the clock.
+func (m *Manual) Waiters() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.timers)
+}
+
+// Next returns the earliest deadline of the timers and sleeps waiting on the clock, false if none is waiting.
+func (m *Manual) Next() (time.Time, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if len(m.timers) == 0 {
+		return time.Time{}, false
+	}
+	next := m.timers[0].deadline
+	for _, t := range m.timers[1:] {
+		if t.deadline.Before(next) {
+			next = t.deadline
+		}
+	}
+	return next, true
+}
+
+// BlockUntil waits until n timers or sleeps are waiting on the clock,
+// so the time moves after the goroutines are ready for it.
+func (m *Manual) BlockUntil(n int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for len(m.timers) < n {
+		m.cond.Wait()
+	}
+}
+
+func (m *Manual) stop(t *manualTimer) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for i, timer := range m.timers {
+		if timer == t {
+			m.timers = append(m.timers[:i], m.timers[i+1:]...)
+			m.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+type manualTimer struct {
+	clock    *Manual
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func (t *manualTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *manualTimer) Stop() bool {
+	return t.clock.stop(t)
+}